@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	azureDefaultAuthority = "https://login.microsoftonline.com"
+	azureScope            = "https://management.azure.com/.default"
+	// acrUsername is the Docker username that goes with an ACR refresh token
+	acrUsername = "00000000-0000-0000-0000-000000000000"
+	// acrRefreshTokenLifetime is how long ACR refresh tokens are valid
+	acrRefreshTokenLifetime = 3 * time.Hour
+)
+
+// acrProvider logs in to Azure Container Registry: it gets an Azure AD access
+// token for a service principal and exchanges it with each registry for a
+// refresh token
+type acrProvider struct {
+	// Registries are the hosts to log in to, e.g. myregistry.azurecr.io
+	Registries   []string
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// Authority is the Azure AD endpoint
+	Authority string
+	// scheme is replaced by http in tests
+	scheme     string
+	httpClient *http.Client
+}
+
+func newAcrProvider(registries []string, tenantID, clientID, clientSecret string) *acrProvider {
+	return &acrProvider{
+		Registries:   registries,
+		TenantID:     tenantID,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Authority:    azureDefaultAuthority,
+		scheme:       "https",
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *acrProvider) Name() string {
+	return "azure"
+}
+
+func (p *acrProvider) Tokens(ctx context.Context) ([]registryAuth, []providerFailure) {
+	accessToken, err := p.accessToken(ctx)
+	if err != nil {
+		var failures []providerFailure
+		for _, registry := range p.Registries {
+			failures = append(failures, newProviderFailure(p.Name(), registry, err))
+		}
+		return nil, failures
+	}
+	var tokens []registryAuth
+	var failures []providerFailure
+	for _, registry := range p.Registries {
+		refreshToken, err := p.exchange(ctx, registry, accessToken)
+		if err != nil {
+			failures = append(failures, newProviderFailure(p.Name(), registry, err))
+			continue
+		}
+		expiresAt := now().Add(acrRefreshTokenLifetime)
+		tokens = append(tokens, registryAuth{
+			Endpoint:  "https://" + registry,
+			Auth:      basicAuth(acrUsername, refreshToken),
+			ExpiresAt: &expiresAt,
+		})
+	}
+	return tokens, failures
+}
+
+// accessToken performs the client credentials grant for the service principal
+func (p *acrProvider) accessToken(ctx context.Context) (string, error) {
+	endpoint := fmt.Sprintf("%s/%s/oauth2/v2.0/token", p.Authority, url.PathEscape(p.TenantID))
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := postForm(ctx, p.httpClient, endpoint, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"scope":         {azureScope},
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.AccessToken == "" {
+		return "", fmt.Errorf("No access token in response from %s", endpoint)
+	}
+	return resp.AccessToken, nil
+}
+
+// exchange trades an Azure AD access token for a registry refresh token
+func (p *acrProvider) exchange(ctx context.Context, registry, accessToken string) (string, error) {
+	endpoint := fmt.Sprintf("%s://%s/oauth2/exchange", p.scheme, registry)
+	var resp struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := postForm(ctx, p.httpClient, endpoint, url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"tenant":       {p.TenantID},
+		"access_token": {accessToken},
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.RefreshToken == "" {
+		return "", fmt.Errorf("No refresh token in response from %s", endpoint)
+	}
+	return resp.RefreshToken, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+)
+
+// registryToken is a decoded registry login and the Rancher registry host it
+// belongs to
+type registryToken struct {
+	Endpoint string
+	Provider string
+	Host     string
+	// Info is only set for ECR hosts
+	Info     ecrHostInfo
+	Username string
+	Password string
+}
+
+// createRegistry creates a registry for the token's host, then adds the
+// credential to it
+func (r *Rancher) createRegistry(
+	ctx context.Context,
+	token registryToken,
+	result targetResult,
+	cache *registryCache,
+	registryClient client.RegistryOperations,
+	registryCredentialClient client.RegistryCredentialOperations) targetResult {
+
+	if ctx.Err() != nil {
+		return result.cancelled(ctx.Err())
+	}
+	if r.IsLeader != nil && !r.IsLeader() {
+		log.Printf("[%s] No longer the leader, not creating registry for host: %s\n", token.Endpoint, token.Host)
+		return result.cancelled(errNotLeader)
+	}
+	log.Printf("[%s] Automatically creating registry for host: %s\n", token.Endpoint, token.Host)
+	registry, err := registryClient.Create(&client.Registry{
+		ServerAddress: token.Host,
+		Name:          token.expand(r.autoCreateName(token)),
+		Description:   token.expand(r.autoCreateDescription(token)),
+		Data:          r.ownershipData(token),
+	})
+	if err != nil {
+		log.Printf("[%s] Error creating registry for host: %s, %s\n", token.Endpoint, token.Host, err)
+		return result.failed(err)
+	}
+	log.Printf("[%s] Created registry %s\n", token.Endpoint, registry.Id)
+	result.RegistryID = registry.Id
+	cache.AddRegistry(*registry)
+
+	result = r.addCredential(ctx, token, *registry, result, cache, registryClient, registryCredentialClient)
+	// don't leave a registry without credentials behind; if this fails too
+	// the next run adopts the registry instead. Once the credential exists
+	// the registry is kept with it, and nothing is deleted after shutdown
+	// began.
+	if result.Status != statusCreated && result.CredentialID == "" && ctx.Err() == nil {
+		log.Printf("[%s] Rolling back registry %s\n", token.Endpoint, registry.Id)
+		if err := registryClient.Delete(registry); err != nil {
+			log.Printf("[%s] Error rolling back registry %s: %s\n", token.Endpoint, registry.Id, err)
+		} else {
+			cache.RemoveRegistry(registry.Id)
+		}
+	}
+	return result
+}
+
+// addCredential waits for a registry to become active and creates its
+// credential
+func (r *Rancher) addCredential(
+	ctx context.Context,
+	token registryToken,
+	registry client.Registry,
+	result targetResult,
+	cache *registryCache,
+	registryClient client.RegistryOperations,
+	registryCredentialClient client.RegistryCredentialOperations) targetResult {
+
+	_, err := r.waitForState(ctx, stateActive, registryState(&registry), func() (resourceState, error) {
+		current, err := registryClient.ById(registry.Id)
+		if err != nil {
+			return resourceState{}, err
+		}
+		if current == nil {
+			return resourceState{}, fmt.Errorf("Registry %s was removed", registry.Id)
+		}
+		return registryState(current), nil
+	})
+	if err != nil {
+		log.Printf("[%s] Registry %s did not become active: %s\n", token.Endpoint, registry.Id, err)
+		return result.failed(err)
+	}
+
+	credential, err := registryCredentialClient.Create(&client.RegistryCredential{
+		RegistryId:  registry.Id,
+		PublicValue: token.Username,
+		SecretValue: token.Password,
+		Email:       "not-really@required.anymore",
+		Name:        token.expand(r.autoCreateName(token)),
+		Description: token.expand(r.autoCreateDescription(token)),
+		Data:        r.credentialData(token),
+	})
+	if err != nil {
+		log.Printf("[%s] Error creating registry credential for host: %s, %s\n", token.Endpoint, token.Host, err)
+		return result.failed(err)
+	}
+	result.CredentialID = credential.Id
+	settled, err := r.settleCredential(ctx, token.Endpoint, credential, registryCredentialClient)
+	cache.SetCredentials(registry.Id, []client.RegistryCredential{*settled})
+	result.State = settled.State
+	if err != nil {
+		log.Printf("[%s] Registry credential %s did not become active: %s\n", token.Endpoint, credential.Id, err)
+		return result.failed(err)
+	}
+	log.Printf("[%s] Successfully created credential %s for registry %s\n", token.Endpoint, credential.Id, registry.Id)
+	result.Status = statusCreated
+	return result
+}
+
+func registryState(registry *client.Registry) resourceState {
+	return resourceState{
+		State:                registry.State,
+		Transitioning:        registry.Transitioning,
+		TransitioningMessage: registry.TransitioningMessage,
+	}
+}
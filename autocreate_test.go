@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func mockAuthorizationToken() *mocks.ECRAPI {
+	mockEcr := new(mocks.ECRAPI)
+	mockEcr.On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{}).Return(
+		&ecr.GetAuthorizationTokenOutput{
+			AuthorizationData: []*ecr.AuthorizationData{{
+				ProxyEndpoint:      aws.String("https://012345678910.dkr.ecr.us-east-1.amazonaws.com"),
+				AuthorizationToken: aws.String(base64.StdEncoding.EncodeToString([]byte("mockUser:mockPassword"))),
+			}},
+		}, nil)
+	return mockEcr
+}
+
+func TestAutoCreate_rollsBackRegistry(t *testing.T) {
+	r := &Rancher{AutoCreate: true}
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{}, nil)
+	registry := &client.Registry{Resource: client.Resource{Id: "1r1"}, ServerAddress: "012345678910.dkr.ecr.us-east-1.amazonaws.com"}
+	mockRegistry.On("Create", mock.AnythingOfType("*client.Registry")).Return(registry, nil)
+	mockRegistryCredential.On("Create", mock.AnythingOfType("*client.RegistryCredential")).Return(nil, errors.New("boom"))
+	mockRegistry.On("Delete", registry).Return(nil)
+
+	rep := r.updateEcr(context.Background(), mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	mockRegistry.AssertExpectations(t)
+	mockRegistryCredential.AssertExpectations(t)
+	assert.Equal(t, statusFailed, rep.Results[0].Status)
+}
+
+func TestAutoCreate_skipsAfterLosingLease(t *testing.T) {
+	r := &Rancher{AutoCreate: true, IsLeader: func() bool { return false }}
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{}, nil)
+
+	rep := r.updateEcr(context.Background(), mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	mockRegistry.AssertNotCalled(t, "Create", mock.Anything)
+	assert.Equal(t, statusCancelled, rep.Results[0].Status)
+	assert.Equal(t, errNotLeader.Error(), rep.Results[0].Error)
+}
+
+func TestAutoCreate_keepsRegistryOnceCredentialExists(t *testing.T) {
+	r := &Rancher{AutoCreate: true, TransitionTimeout: time.Second, TransitionPollInterval: time.Millisecond}
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{}, nil)
+	registry := &client.Registry{Resource: client.Resource{Id: "1r1"}, ServerAddress: "012345678910.dkr.ecr.us-east-1.amazonaws.com", State: stateActive}
+	mockRegistry.On("Create", mock.AnythingOfType("*client.Registry")).Return(registry, nil)
+	// the credential is created but fails to settle
+	mockRegistryCredential.On("Create", mock.AnythingOfType("*client.RegistryCredential")).Return(
+		&client.RegistryCredential{Resource: client.Resource{Id: "1rc1"}, State: "error", Transitioning: transitioningError}, nil)
+
+	rep := r.updateEcr(context.Background(), mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	mockRegistry.AssertNotCalled(t, "Delete", mock.Anything)
+	assert.Equal(t, statusFailed, rep.Results[0].Status)
+	assert.Equal(t, "1rc1", rep.Results[0].CredentialID)
+}
+
+func TestAutoCreate_noRollbackAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Rancher{AutoCreate: true}
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{}, nil)
+	registry := &client.Registry{Resource: client.Resource{Id: "1r1"}, ServerAddress: "012345678910.dkr.ecr.us-east-1.amazonaws.com"}
+	mockRegistry.On("Create", mock.AnythingOfType("*client.Registry")).Run(func(mock.Arguments) { cancel() }).Return(registry, nil)
+	mockRegistryCredential.On("Create", mock.AnythingOfType("*client.RegistryCredential")).Return(nil, errors.New("boom"))
+
+	r.updateEcr(ctx, mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	mockRegistry.AssertNotCalled(t, "Delete", mock.Anything)
+}
+
+func TestAutoCreate_adoptsRegistryWithoutCredential(t *testing.T) {
+	r := &Rancher{AutoCreate: true}
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{
+		Data: []client.Registry{{
+			Resource:      client.Resource{Id: "1r1"},
+			ServerAddress: "012345678910.dkr.ecr.us-east-1.amazonaws.com",
+			State:         stateActive,
+		}},
+	}, nil)
+	mockRegistryCredential.On("List", &client.ListOpts{
+		Filters: map[string]interface{}{"registryId": "1r1"},
+	}).Return(&client.RegistryCredentialCollection{}, nil)
+	mockRegistryCredential.On("Create", mock.MatchedBy(func(c *client.RegistryCredential) bool {
+		return c.RegistryId == "1r1" && c.PublicValue == "mockUser"
+	})).Return(&client.RegistryCredential{Resource: client.Resource{Id: "1rc1"}}, nil)
+
+	rep := r.updateEcr(context.Background(), mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	mockRegistry.AssertExpectations(t)
+	mockRegistry.AssertNotCalled(t, "Create", mock.Anything)
+	mockRegistryCredential.AssertExpectations(t)
+	assert.Equal(t, statusCreated, rep.Results[0].Status)
+	assert.Equal(t, "1rc1", rep.Results[0].CredentialID)
+}
+
+func TestAutoCreate_waitsForActiveRegistry(t *testing.T) {
+	r := &Rancher{AutoCreate: true, TransitionTimeout: time.Second, TransitionPollInterval: time.Millisecond}
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{}, nil)
+	mockRegistry.On("Create", mock.AnythingOfType("*client.Registry")).Return(
+		&client.Registry{Resource: client.Resource{Id: "1r1"}, State: "registering", Transitioning: "yes"}, nil)
+	mockRegistry.On("ById", "1r1").Return(
+		&client.Registry{Resource: client.Resource{Id: "1r1"}, State: "activating", Transitioning: "yes"}, nil).Once()
+	mockRegistry.On("ById", "1r1").Return(
+		&client.Registry{Resource: client.Resource{Id: "1r1"}, State: stateActive, Transitioning: "no"}, nil).Once()
+	mockRegistryCredential.On("Create", mock.AnythingOfType("*client.RegistryCredential")).Return(
+		&client.RegistryCredential{Resource: client.Resource{Id: "1rc1"}, State: stateActive}, nil)
+
+	rep := r.updateEcr(context.Background(), mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	mockRegistry.AssertExpectations(t)
+	mockRegistryCredential.AssertExpectations(t)
+	assert.Equal(t, statusCreated, rep.Results[0].Status)
+}
+
+func TestAutoCreate_lookupErrorDoesNotCreate(t *testing.T) {
+	r := &Rancher{AutoCreate: true}
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{
+		Data: []client.Registry{{Resource: client.Resource{Id: "1r1"}, ServerAddress: "012345678910.dkr.ecr.us-east-1.amazonaws.com"}},
+	}, nil)
+	mockRegistryCredential.On("List", mock.Anything).Return(nil, errors.New("boom"))
+
+	rep := r.updateEcr(context.Background(), mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	mockRegistry.AssertNotCalled(t, "Create", mock.Anything)
+	assert.Equal(t, statusFailed, rep.Results[0].Status)
+}
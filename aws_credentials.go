@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/go-ini/ini"
+)
+
+// credentialsExpiryWindow refreshes temporary credentials this long before
+// they expire
+const credentialsExpiryWindow = time.Minute
+
+// maxSourceProfiles bounds a chain of profiles naming a source_profile
+const maxSourceProfiles = 5
+
+// maxProcessStderr bounds how much of a failed credential process's stderr
+// is included in the error
+const maxProcessStderr = 512
+
+// profileKeys are the profile settings resolved by the updater. The vendored
+// SDK only reads keys from the credentials file, so profiles are resolved
+// here and other settings, such as region or the sso_ ones, are not applied.
+var profileKeys = map[string]bool{
+	"credential_process":      true,
+	"role_arn":                true,
+	"source_profile":          true,
+	"web_identity_token_file": true,
+	"external_id":             true,
+	"role_session_name":       true,
+	"duration_seconds":        true,
+	"mfa_serial":              true,
+	"aws_access_key_id":       true,
+	"aws_secret_access_key":   true,
+	"aws_session_token":       true,
+	"output":                  true,
+}
+
+// assumeRoleConfig describes a role assumed on top of other credentials
+type assumeRoleConfig struct {
+	ARN         string
+	ExternalID  string
+	SessionName string
+	Duration    time.Duration
+	// MFASerial is the MFA device the role requires. The current code is
+	// read from MFATokenFile whenever the role is assumed, e.g. as written by
+	// a sidecar.
+	MFASerial    string
+	MFATokenFile string
+}
+
+// credentialsConfig selects where the AWS credentials come from. At most one
+// of the key files, CredentialProcess, Profile and WebIdentityTokenFile may be
+// set; without any the SDK's default chain is used. Role is then assumed with
+// the result, except with WebIdentityTokenFile where it is the role the token
+// is exchanged for.
+type credentialsConfig struct {
+	AccessKeyFile        string
+	SecretKeyFile        string
+	SessionTokenFile     string
+	CredentialProcess    string
+	Profile              string
+	ConfigFile           string
+	CredentialsFile      string
+	WebIdentityTokenFile string
+	Role                 assumeRoleConfig
+	// Clients configures the STS clients used to assume roles
+	Clients awsClientConfig
+}
+
+// credentialsConfigFromEnv reads the credential settings from the environment
+func credentialsConfigFromEnv() credentialsConfig {
+	c := credentialsConfig{
+		AccessKeyFile:        os.Getenv("AWS_ACCESS_KEY_ID_FILE"),
+		SecretKeyFile:        os.Getenv("AWS_SECRET_ACCESS_KEY_FILE"),
+		SessionTokenFile:     os.Getenv("AWS_SESSION_TOKEN_FILE"),
+		CredentialProcess:    os.Getenv("AWS_CREDENTIAL_PROCESS"),
+		Profile:              os.Getenv("AWS_PROFILE"),
+		ConfigFile:           os.Getenv("AWS_CONFIG_FILE"),
+		CredentialsFile:      os.Getenv("AWS_SHARED_CREDENTIALS_FILE"),
+		WebIdentityTokenFile: os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
+		Role: assumeRoleConfig{
+			ARN:          os.Getenv("AWS_ROLE_ARN"),
+			ExternalID:   os.Getenv("AWS_ROLE_EXTERNAL_ID"),
+			SessionName:  os.Getenv("AWS_ROLE_SESSION_NAME"),
+			Duration:     lookupDuration("AWS_ROLE_DURATION", 0),
+			MFASerial:    os.Getenv("AWS_ROLE_MFA_SERIAL"),
+			MFATokenFile: os.Getenv("AWS_MFA_TOKEN_FILE"),
+		},
+	}
+	home := os.Getenv("HOME")
+	if c.ConfigFile == "" {
+		c.ConfigFile = filepath.Join(home, ".aws", "config")
+	}
+	if c.CredentialsFile == "" {
+		c.CredentialsFile = filepath.Join(home, ".aws", "credentials")
+	}
+	return c
+}
+
+// build returns the credentials and a description of their source that is
+// safe to log. Nil credentials select the SDK's default chain.
+func (c credentialsConfig) build() (*credentials.Credentials, string, error) {
+	var sources []string
+	if c.AccessKeyFile != "" || c.SecretKeyFile != "" {
+		sources = append(sources, "AWS_ACCESS_KEY_ID_FILE")
+	}
+	if c.CredentialProcess != "" {
+		sources = append(sources, "AWS_CREDENTIAL_PROCESS")
+	}
+	if c.Profile != "" {
+		sources = append(sources, "AWS_PROFILE")
+	}
+	if c.WebIdentityTokenFile != "" {
+		sources = append(sources, "AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if len(sources) > 1 {
+		return nil, "", fmt.Errorf("Only one AWS credential source may be set, got %s", strings.Join(sources, ", "))
+	}
+
+	var creds *credentials.Credentials
+	description := "the default chain (environment, shared credentials file, instance role)"
+	switch {
+	case c.AccessKeyFile != "" || c.SecretKeyFile != "":
+		value, err := c.readKeyFiles()
+		if err != nil {
+			return nil, "", err
+		}
+		creds = credentials.NewStaticCredentials(value.AccessKeyID, value.SecretAccessKey, value.SessionToken)
+		description = fmt.Sprintf("key files %s and %s", c.AccessKeyFile, c.SecretKeyFile)
+	case c.CredentialProcess != "":
+		creds = credentials.NewCredentials(&processProvider{Command: c.CredentialProcess})
+		description = "credential process"
+	case c.Profile != "":
+		var err error
+		if creds, description, err = c.profile(c.Profile, 0); err != nil {
+			return nil, "", err
+		}
+	case c.WebIdentityTokenFile != "":
+		if c.Role.ARN == "" {
+			return nil, "", fmt.Errorf("AWS_ROLE_ARN is required with AWS_WEB_IDENTITY_TOKEN_FILE")
+		}
+		creds = newWebIdentityCredentials(c.Role, c.WebIdentityTokenFile, c.Clients)
+		return creds, fmt.Sprintf("web identity token %s for role %s", c.WebIdentityTokenFile, c.Role.ARN), nil
+	}
+	if c.Role.ARN != "" {
+		creds = newAssumeRoleCredentials(creds, c.Role, c.Clients)
+		description = fmt.Sprintf("role %s assumed with %s", c.Role.ARN, description)
+	}
+	return creds, description, nil
+}
+
+func (c credentialsConfig) readKeyFiles() (credentials.Value, error) {
+	if c.AccessKeyFile == "" || c.SecretKeyFile == "" {
+		return credentials.Value{}, fmt.Errorf("AWS_ACCESS_KEY_ID_FILE and AWS_SECRET_ACCESS_KEY_FILE must be set together")
+	}
+	value := credentials.Value{ProviderName: "KeyFiles"}
+	for path, field := range map[string]*string{
+		c.AccessKeyFile:    &value.AccessKeyID,
+		c.SecretKeyFile:    &value.SecretAccessKey,
+		c.SessionTokenFile: &value.SessionToken,
+	} {
+		if path == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return value, fmt.Errorf("Unable to read AWS key file: %s", err)
+		}
+		*field = strings.TrimSpace(string(b))
+	}
+	return value, nil
+}
+
+// profile resolves a named profile of the shared config and credentials
+// files, following source_profile
+func (c credentialsConfig) profile(name string, depth int) (*credentials.Credentials, string, error) {
+	if depth >= maxSourceProfiles {
+		return nil, "", fmt.Errorf("Profile %s: source_profile chain is longer than %d", name, maxSourceProfiles)
+	}
+	section := "profile " + name
+	if name == "default" {
+		section = name
+	}
+	config := ini.Empty()
+	if _, err := os.Stat(c.ConfigFile); err == nil {
+		if config, err = ini.Load(c.ConfigFile); err != nil {
+			return nil, "", fmt.Errorf("Unable to parse %s: %s", c.ConfigFile, err)
+		}
+	}
+	settings := config.Section(section)
+	var ignored []string
+	for _, key := range settings.KeyStrings() {
+		if !profileKeys[key] {
+			ignored = append(ignored, key)
+		}
+	}
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		log.Warnf("[awsClient] Profile %s: ignoring %s, which the updater does not support", name, strings.Join(ignored, ", "))
+	}
+
+	if process := settings.Key("credential_process").String(); process != "" {
+		return credentials.NewCredentials(&processProvider{Command: process}), fmt.Sprintf("credential process of profile %s", name), nil
+	}
+	roleARN := settings.Key("role_arn").String()
+	if roleARN == "" {
+		// keys in the credentials file, or in the config file
+		keys, err := ini.Load(c.CredentialsFile)
+		if err == nil && keys.Section(name).Key("aws_access_key_id").String() != "" {
+			return credentials.NewSharedCredentials(c.CredentialsFile, name), fmt.Sprintf("profile %s in %s", name, c.CredentialsFile), nil
+		}
+		if id := settings.Key("aws_access_key_id").String(); id != "" {
+			return credentials.NewStaticCredentials(id, settings.Key("aws_secret_access_key").String(), settings.Key("aws_session_token").String()),
+				fmt.Sprintf("profile %s in %s", name, c.ConfigFile), nil
+		}
+		if settings.Key("sso_start_url").String() != "" || settings.Key("sso_session").String() != "" {
+			return nil, "", fmt.Errorf("Profile %s uses AWS SSO, which is not supported; use credential_process or a role instead", name)
+		}
+		return nil, "", fmt.Errorf("Profile %s has no credentials in %s or %s", name, c.ConfigFile, c.CredentialsFile)
+	}
+
+	role := assumeRoleConfig{
+		ARN:          roleARN,
+		ExternalID:   settings.Key("external_id").String(),
+		SessionName:  settings.Key("role_session_name").String(),
+		MFASerial:    settings.Key("mfa_serial").String(),
+		MFATokenFile: c.Role.MFATokenFile,
+	}
+	if seconds := settings.Key("duration_seconds").String(); seconds != "" {
+		n, err := strconv.Atoi(seconds)
+		if err != nil {
+			return nil, "", fmt.Errorf("Profile %s: invalid duration_seconds %q", name, seconds)
+		}
+		role.Duration = time.Duration(n) * time.Second
+	}
+	if tokenFile := settings.Key("web_identity_token_file").String(); tokenFile != "" {
+		return newWebIdentityCredentials(role, tokenFile, c.Clients), fmt.Sprintf("web identity token %s for role %s of profile %s", tokenFile, roleARN, name), nil
+	}
+	source := settings.Key("source_profile").String()
+	if source == "" {
+		return nil, "", fmt.Errorf("Profile %s sets role_arn without source_profile or web_identity_token_file", name)
+	}
+	base, description, err := c.profile(source, depth+1)
+	if err != nil {
+		return nil, "", err
+	}
+	return newAssumeRoleCredentials(base, role, c.Clients), fmt.Sprintf("role %s of profile %s assumed with %s", roleARN, name, description), nil
+}
+
+// assumeRoleAPI is the part of the STS client used to assume roles
+type assumeRoleAPI interface {
+	AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error)
+	AssumeRoleWithWebIdentity(input *sts.AssumeRoleWithWebIdentityInput) (*sts.AssumeRoleWithWebIdentityOutput, error)
+}
+
+// assumeRoleProvider assumes a role like stscreds.AssumeRoleProvider, adding
+// the MFA device of the role
+type assumeRoleProvider struct {
+	credentials.Expiry
+	Role   assumeRoleConfig
+	client assumeRoleAPI
+}
+
+func newAssumeRoleCredentials(base *credentials.Credentials, role assumeRoleConfig, clients awsClientConfig) *credentials.Credentials {
+	return credentials.NewCredentials(&assumeRoleProvider{Role: role, client: clients.sts(base)})
+}
+
+func (p *assumeRoleProvider) Retrieve() (credentials.Value, error) {
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(p.Role.ARN),
+		RoleSessionName: aws.String(roleSessionName(p.Role)),
+	}
+	if p.Role.Duration > 0 {
+		input.DurationSeconds = aws.Int64(int64(p.Role.Duration / time.Second))
+	}
+	if p.Role.ExternalID != "" {
+		input.ExternalId = aws.String(p.Role.ExternalID)
+	}
+	if p.Role.MFASerial != "" {
+		if p.Role.MFATokenFile == "" {
+			return credentials.Value{}, fmt.Errorf("Role %s requires MFA but AWS_MFA_TOKEN_FILE is not set", p.Role.ARN)
+		}
+		b, err := ioutil.ReadFile(p.Role.MFATokenFile)
+		if err != nil {
+			return credentials.Value{}, fmt.Errorf("Unable to read MFA token: %s", err)
+		}
+		input.SerialNumber = aws.String(p.Role.MFASerial)
+		input.TokenCode = aws.String(strings.TrimSpace(string(b)))
+	}
+	resp, err := p.client.AssumeRole(input)
+	if err != nil {
+		return credentials.Value{ProviderName: "AssumeRoleProvider"}, err
+	}
+	p.SetExpiration(aws.TimeValue(resp.Credentials.Expiration), credentialsExpiryWindow)
+	return stsValue(resp.Credentials, "AssumeRoleProvider"), nil
+}
+
+// webIdentityProvider exchanges an OIDC token, re-read from TokenFile on
+// every refresh, for the credentials of a role
+type webIdentityProvider struct {
+	credentials.Expiry
+	Role      assumeRoleConfig
+	TokenFile string
+	client    assumeRoleAPI
+}
+
+func newWebIdentityCredentials(role assumeRoleConfig, tokenFile string, clients awsClientConfig) *credentials.Credentials {
+	// the token is the only credential, so the request is not signed
+	return credentials.NewCredentials(&webIdentityProvider{Role: role, TokenFile: tokenFile, client: clients.sts(credentials.AnonymousCredentials)})
+}
+
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	b, err := ioutil.ReadFile(p.TokenFile)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("Unable to read web identity token: %s", err)
+	}
+	input := &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.Role.ARN),
+		RoleSessionName:  aws.String(roleSessionName(p.Role)),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(b))),
+	}
+	if p.Role.Duration > 0 {
+		input.DurationSeconds = aws.Int64(int64(p.Role.Duration / time.Second))
+	}
+	resp, err := p.client.AssumeRoleWithWebIdentity(input)
+	if err != nil {
+		return credentials.Value{ProviderName: "WebIdentityProvider"}, err
+	}
+	p.SetExpiration(aws.TimeValue(resp.Credentials.Expiration), credentialsExpiryWindow)
+	return stsValue(resp.Credentials, "WebIdentityProvider"), nil
+}
+
+func roleSessionName(role assumeRoleConfig) string {
+	if role.SessionName != "" {
+		return role.SessionName
+	}
+	return fmt.Sprintf("rancher-ecr-credentials-%d", now().Unix())
+}
+
+func stsValue(c *sts.Credentials, provider string) credentials.Value {
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(c.AccessKeyId),
+		SecretAccessKey: aws.StringValue(c.SecretAccessKey),
+		SessionToken:    aws.StringValue(c.SessionToken),
+		ProviderName:    provider,
+	}
+}
+
+// processProvider runs a credential_process command, which prints the
+// credentials as JSON
+type processProvider struct {
+	credentials.Expiry
+	Command string
+}
+
+// processCredentials is the output of a credential_process command
+type processCredentials struct {
+	Version         int
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      *time.Time
+}
+
+func (p *processProvider) Retrieve() (credentials.Value, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", p.Command)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.Env = os.Environ()
+	// stdout holds secrets, so it is never part of an error; stderr is for
+	// diagnostics and is included, truncated, when the process fails
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			if len(msg) > maxProcessStderr {
+				msg = msg[:maxProcessStderr] + "..."
+			}
+			return credentials.Value{}, fmt.Errorf("Credential process failed: %s: %s", err, msg)
+		}
+		return credentials.Value{}, fmt.Errorf("Credential process failed: %s", err)
+	}
+	var out processCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return credentials.Value{}, fmt.Errorf("Credential process printed invalid JSON")
+	}
+	if out.Version != 1 {
+		return credentials.Value{}, fmt.Errorf("Credential process printed unsupported Version %d", out.Version)
+	}
+	if out.AccessKeyID == "" || out.SecretAccessKey == "" {
+		return credentials.Value{}, fmt.Errorf("Credential process printed no AccessKeyId or SecretAccessKey")
+	}
+	if out.Expiration != nil {
+		p.SetExpiration(*out.Expiration, credentialsExpiryWindow)
+	} else {
+		// credentials without an expiry are kept for good
+		p.SetExpiration(now().AddDate(100, 0, 0), 0)
+	}
+	return credentials.Value{
+		AccessKeyID:     out.AccessKeyID,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.SessionToken,
+		ProviderName:    "ProcessProvider",
+	}, nil
+}
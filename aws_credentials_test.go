@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSTS records the requests made to assume a role
+type fakeSTS struct {
+	assumeRole  *sts.AssumeRoleInput
+	webIdentity *sts.AssumeRoleWithWebIdentityInput
+}
+
+func (f *fakeSTS) credentials() *sts.Credentials {
+	return &sts.Credentials{
+		AccessKeyId:     aws.String("ASIAEXAMPLE"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}
+}
+
+func (f *fakeSTS) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	f.assumeRole = input
+	return &sts.AssumeRoleOutput{Credentials: f.credentials()}, nil
+}
+
+func (f *fakeSTS) AssumeRoleWithWebIdentity(input *sts.AssumeRoleWithWebIdentityInput) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	f.webIdentity = input
+	return &sts.AssumeRoleWithWebIdentityOutput{Credentials: f.credentials()}, nil
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestCredentials_profileChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := credentialsConfig{
+		Profile: "deploy",
+		ConfigFile: writeTestFile(t, dir, "config", `
+[profile deploy]
+role_arn = arn:aws:iam::012345678910:role/deploy
+source_profile = base
+external_id = rancher
+
+[profile tool]
+credential_process = /bin/creds
+region = eu-west-1
+
+[profile sso]
+sso_start_url = https://example.awsapps.com/start
+sso_account_id = 012345678910
+`),
+		CredentialsFile: writeTestFile(t, dir, "credentials", `
+[base]
+aws_access_key_id = AKIAEXAMPLE
+aws_secret_access_key = do-not-log
+`),
+	}
+
+	creds, description, err := c.build()
+	assert.NoError(t, err)
+	assert.NotNil(t, creds)
+	assert.Equal(t, "role arn:aws:iam::012345678910:role/deploy of profile deploy assumed with profile base in "+c.CredentialsFile, description)
+	assert.NotContains(t, description, "do-not-log")
+
+	c.Profile = "tool"
+	_, description, err = c.build()
+	assert.NoError(t, err)
+	assert.Equal(t, "credential process of profile tool", description)
+
+	c.Profile = "sso"
+	_, _, err = c.build()
+	assert.EqualError(t, err, "Profile sso uses AWS SSO, which is not supported; use credential_process or a role instead")
+
+	c.Profile = "missing"
+	_, _, err = c.build()
+	assert.Error(t, err)
+
+	c.Profile, c.CredentialProcess = "deploy", "/bin/creds"
+	_, _, err = c.build()
+	assert.EqualError(t, err, "Only one AWS credential source may be set, got AWS_CREDENTIAL_PROCESS, AWS_PROFILE")
+}
+
+func TestCredentials_assumeRoleWithMFA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	client := &fakeSTS{}
+	p := &assumeRoleProvider{
+		Role: assumeRoleConfig{
+			ARN:          "arn:aws:iam::012345678910:role/deploy",
+			ExternalID:   "rancher",
+			SessionName:  "updater",
+			Duration:     time.Hour,
+			MFASerial:    "arn:aws:iam::012345678910:mfa/ops",
+			MFATokenFile: writeTestFile(t, dir, "mfa", "123456\n"),
+		},
+		client: client,
+	}
+
+	value, err := p.Retrieve()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ASIAEXAMPLE", value.AccessKeyID)
+	assert.False(t, p.IsExpired())
+	assert.Equal(t, &sts.AssumeRoleInput{
+		RoleArn:         aws.String("arn:aws:iam::012345678910:role/deploy"),
+		RoleSessionName: aws.String("updater"),
+		DurationSeconds: aws.Int64(3600),
+		ExternalId:      aws.String("rancher"),
+		SerialNumber:    aws.String("arn:aws:iam::012345678910:mfa/ops"),
+		TokenCode:       aws.String("123456"),
+	}, client.assumeRole)
+}
+
+func TestCredentials_webIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	client := &fakeSTS{}
+	p := &webIdentityProvider{
+		Role:      assumeRoleConfig{ARN: "arn:aws:iam::012345678910:role/deploy", SessionName: "updater"},
+		TokenFile: writeTestFile(t, dir, "token", "eyJtoken\n"),
+		client:    client,
+	}
+
+	_, err = p.Retrieve()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "eyJtoken", aws.StringValue(client.webIdentity.WebIdentityToken))
+}
+
+func TestCredentials_process(t *testing.T) {
+	p := &processProvider{Command: `echo '{"Version": 1, "AccessKeyId": "AKIAEXAMPLE", "SecretAccessKey": "secret", "Expiration": "2099-01-01T00:00:00Z"}'`}
+	value, err := p.Retrieve()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIAEXAMPLE", value.AccessKeyID)
+	assert.False(t, p.IsExpired())
+
+	p = &processProvider{Command: `echo '{"AccessKeyId": "AKIAEXAMPLE"}'; echo secret >&2`}
+	_, err = p.Retrieve()
+	assert.EqualError(t, err, "Credential process printed unsupported Version 0")
+
+	p = &processProvider{Command: "exit 1"}
+	_, err = p.Retrieve()
+	assert.EqualError(t, err, "Credential process failed: exit status 1")
+
+	p = &processProvider{Command: "echo 'token expired, run login' >&2; exit 2"}
+	_, err = p.Retrieve()
+	assert.EqualError(t, err, "Credential process failed: exit status 2: token expired, run login")
+}
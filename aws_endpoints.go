@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// awsRegionPattern matches a region in an AWS endpoint host, such as the
+// eu-west-1 of vpce-0abc.api.ecr.eu-west-1.vpce.amazonaws.com
+var awsRegionPattern = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-[0-9]$`)
+
+// awsAccountPattern matches an AWS account ID
+var awsAccountPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// awsClientConfig holds the endpoints and HTTP settings of the AWS clients,
+// e.g. for VPC endpoints, FIPS endpoints or a local ECR stand-in.
+type awsClientConfig struct {
+	// ECREndpoints are ECR endpoint URLs keyed by "account:region", account,
+	// region or "", most specific first; the one for "" applies to every
+	// registry without its own
+	ECREndpoints map[string]string
+	// Region is the default region, used for clients asked for region ""
+	Region string
+	// STSEndpoint serves the identity check and every role assumption;
+	// STSRegion is the region its requests are signed for
+	STSEndpoint string
+	STSRegion   string
+	// HTTPClient, if set, carries the CA bundle, proxy and timeout
+	HTTPClient *http.Client
+}
+
+// awsClientConfigFromEnv reads the endpoints and HTTP settings from the
+// environment
+func awsClientConfigFromEnv() (awsClientConfig, error) {
+	c := awsClientConfig{
+		ECREndpoints: map[string]string{},
+		STSEndpoint:  os.Getenv("AWS_STS_ENDPOINT"),
+		STSRegion:    os.Getenv("AWS_STS_REGION"),
+		Region:       os.Getenv("AWS_REGION"),
+	}
+	if c.STSRegion == "" {
+		c.STSRegion = endpointRegion(c.STSEndpoint)
+	}
+	if endpoint := os.Getenv("AWS_ECR_ENDPOINT"); endpoint != "" {
+		// an endpoint of one region, such as a VPC endpoint, can't serve the
+		// others
+		c.ECREndpoints[endpointRegion(endpoint)] = endpoint
+	}
+	if endpoints := os.Getenv("AWS_ECR_ENDPOINTS"); endpoints != "" {
+		for _, pair := range strings.Split(endpoints, ",") {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 || !validEndpointKey(parts[0]) || parts[1] == "" {
+				return c, fmt.Errorf("AWS_ECR_ENDPOINTS entries must be region=url, account=url or account:region=url, got %q", pair)
+			}
+			c.ECREndpoints[parts[0]] = parts[1]
+		}
+	}
+
+	caBundle := os.Getenv("AWS_CA_BUNDLE")
+	proxy := os.Getenv("AWS_PROXY")
+	timeout := lookupDuration("AWS_HTTP_TIMEOUT", 0)
+	if caBundle == "" && proxy == "" && timeout == 0 {
+		return c, nil
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if caBundle != "" {
+		pool, err := loadCABundle(caBundle)
+		if err != nil {
+			return c, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return c, fmt.Errorf("Invalid AWS_PROXY: %s", err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: timeout}
+	return c, nil
+}
+
+// validEndpointKey reports whether key is a region, an account ID, or both
+// separated by a colon
+func validEndpointKey(key string) bool {
+	parts := strings.Split(key, ":")
+	switch len(parts) {
+	case 1:
+		return key != ""
+	case 2:
+		return awsAccountPattern.MatchString(parts[0]) && parts[1] != ""
+	}
+	return false
+}
+
+// endpointRegion returns the region named in the host of an AWS endpoint URL,
+// or "" for a global or non-AWS endpoint
+func endpointRegion(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || !strings.Contains(u.Host, ".amazonaws.com") {
+		return ""
+	}
+	for _, label := range strings.Split(u.Host, ".") {
+		if awsRegionPattern.MatchString(label) {
+			return label
+		}
+	}
+	return ""
+}
+
+// loadCABundle returns the system roots with the PEM certificates in path
+// added
+func loadCABundle(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read CA bundle: %s", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("No certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
+
+// awsConfig returns the client config for an endpoint, or the default endpoint
+// when it is empty
+func (c awsClientConfig) awsConfig(region, endpoint string, creds *credentials.Credentials) *aws.Config {
+	config := aws.NewConfig()
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	if creds != nil {
+		config = config.WithCredentials(creds)
+	}
+	if c.HTTPClient != nil {
+		config = config.WithHTTPClient(c.HTTPClient)
+	}
+	return config
+}
+
+// sts returns an STS client using creds, or the default chain if nil
+func (c awsClientConfig) sts(creds *credentials.Credentials) *sts.STS {
+	return sts.New(session.New(c.awsConfig(c.STSRegion, c.STSEndpoint, creds)))
+}
+
+// ecrEndpoint returns the ECR endpoint configured for a registry, by account
+// and region, then account, then region. An empty account is the registry of
+// the credentials and an empty region the default one.
+func (c awsClientConfig) ecrEndpoint(account, region string) string {
+	if region == "" {
+		region = c.Region
+	}
+	var keys []string
+	if account != "" {
+		keys = append(keys, account+":"+region, account)
+	}
+	for _, key := range append(keys, region) {
+		if endpoint, ok := c.ECREndpoints[key]; ok {
+			return endpoint
+		}
+	}
+	return c.ECREndpoints[""]
+}
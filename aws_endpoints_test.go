@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpoints_ecrStandIn(t *testing.T) {
+	// a local stand-in for ECR, as LocalStack or a VPC endpoint would be
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken", r.Header.Get("X-Amz-Target"))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(`{"authorizationData": [{"authorizationToken": "dXNlcjpwYXNz", "proxyEndpoint": "https://012345678910.dkr.ecr.eu-west-1.amazonaws.com"}]}`))
+	}))
+	defer server.Close()
+	clients := awsClientConfig{
+		ECREndpoints: map[string]string{"eu-west-1": server.URL, "": "https://ecr-fips.example"},
+		HTTPClient:   &http.Client{},
+	}
+	c := newAWSSessionCache(credentials.NewStaticCredentials("AKIAEXAMPLE", "secret", ""), clients)
+
+	resp, err := c.ECR("", "eu-west-1").GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "dXNlcjpwYXNz", aws.StringValue(resp.AuthorizationData[0].AuthorizationToken))
+	assert.Equal(t, "https://ecr-fips.example", clients.ecrEndpoint("", "us-east-1"))
+}
+
+func TestEndpoints_regionalEndpoints(t *testing.T) {
+	assert.Equal(t, "eu-west-1", endpointRegion("https://vpce-0abc-1234.api.ecr.eu-west-1.vpce.amazonaws.com"))
+	assert.Equal(t, "us-gov-west-1", endpointRegion("https://sts.us-gov-west-1.amazonaws.com"))
+	assert.Equal(t, "", endpointRegion("https://sts.amazonaws.com"))
+	assert.Equal(t, "", endpointRegion("http://localhost:4566"))
+
+	os.Setenv("AWS_ECR_ENDPOINT", "https://vpce-0abc-1234.api.ecr.eu-west-1.vpce.amazonaws.com")
+	os.Setenv("AWS_STS_ENDPOINT", "https://sts.eu-west-1.amazonaws.com")
+	os.Setenv("AWS_REGION", "eu-west-1")
+	defer os.Unsetenv("AWS_ECR_ENDPOINT")
+	defer os.Unsetenv("AWS_STS_ENDPOINT")
+	defer os.Unsetenv("AWS_REGION")
+	c, err := awsClientConfigFromEnv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://vpce-0abc-1234.api.ecr.eu-west-1.vpce.amazonaws.com", c.ecrEndpoint("", "eu-west-1"))
+	// the client of the default region, as used for AWS_ECR_REGISTRY_IDS
+	assert.Equal(t, "https://vpce-0abc-1234.api.ecr.eu-west-1.vpce.amazonaws.com", c.ecrEndpoint("", ""))
+	sessions := newAWSSessionCache(credentials.NewStaticCredentials("AKIAEXAMPLE", "secret", ""), c)
+	assert.Equal(t, "https://vpce-0abc-1234.api.ecr.eu-west-1.vpce.amazonaws.com", sessions.ECR("", "").Endpoint)
+	// other regions keep their default endpoint
+	assert.Equal(t, "", c.ecrEndpoint("", "us-east-1"))
+	assert.Equal(t, "eu-west-1", c.STSRegion)
+}
+
+func TestEndpoints_perAccount(t *testing.T) {
+	os.Setenv("AWS_ECR_ENDPOINTS", "https://ecr.example,eu-west-1=https://ecr-eu.example,"+
+		"109876543210=https://partner.example,109876543210:us-east-1=https://partner-us.example")
+	defer os.Unsetenv("AWS_ECR_ENDPOINTS")
+	_, err := awsClientConfigFromEnv()
+	assert.Error(t, err)
+
+	os.Setenv("AWS_ECR_ENDPOINTS", "eu-west-1=https://ecr-eu.example,"+
+		"109876543210=https://partner.example,109876543210:us-east-1=https://partner-us.example")
+	c, err := awsClientConfigFromEnv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://partner-us.example", c.ecrEndpoint("109876543210", "us-east-1"))
+	assert.Equal(t, "https://partner.example", c.ecrEndpoint("109876543210", "eu-west-1"))
+	assert.Equal(t, "https://ecr-eu.example", c.ecrEndpoint("012345678910", "eu-west-1"))
+	assert.Equal(t, "", c.ecrEndpoint("012345678910", "us-east-1"))
+
+	// accounts that share an endpoint share a client
+	sessions := newAWSSessionCache(credentials.NewStaticCredentials("AKIAEXAMPLE", "secret", ""), c)
+	assert.True(t, sessions.ECR("012345678910", "eu-west-1") == sessions.ECR("555555555555", "eu-west-1"))
+	assert.False(t, sessions.ECR("012345678910", "eu-west-1") == sessions.ECR("109876543210", "eu-west-1"))
+}
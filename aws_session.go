@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// awsSessionCache builds one session and ECR client per region and endpoint
+// and reuses them across cycles. Accounts that share an endpoint share a
+// client, so their tokens can still be requested together. Every client uses
+// the one set of credentials the cache was built with, so only a single role
+// is supported, and STS credentials are only requested again as they expire.
+// Registries in other accounts are reached through their registry policies,
+// not by assuming a role per account.
+type awsSessionCache struct {
+	mu       sync.Mutex
+	creds    *credentials.Credentials
+	config   awsClientConfig
+	sessions map[string]*session.Session
+	clients  map[string]*ecr.ECR
+	sts      *sts.STS
+}
+
+// awsSessions is the cache every AWS client comes from
+var awsSessions = newAWSSessionCache(nil, awsClientConfig{})
+
+// newAWSSessionCache returns a cache using creds, or the SDK's default chain
+// if nil
+func newAWSSessionCache(creds *credentials.Credentials, clients awsClientConfig) *awsSessionCache {
+	return &awsSessionCache{
+		creds:    creds,
+		config:   clients,
+		sessions: map[string]*session.Session{},
+		clients:  map[string]*ecr.ECR{},
+	}
+}
+
+// session returns the session for a region and endpoint, or for the default
+// region when it is empty. Its ECR clients don't retry on their own: getAuthorizationToken
+// retries throttling and server errors with the configured backoff, so SDK
+// retries would only multiply the attempts.
+func (c *awsSessionCache) session(key, region, endpoint string) *session.Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.sessions[key]; ok {
+		return s
+	}
+	s := session.New(c.config.awsConfig(region, endpoint, c.creds).WithMaxRetries(0))
+	c.sessions[key] = s
+	return s
+}
+
+// ECR returns the ECR client for the registries of an account in a region.
+// An empty account is the registry of the credentials.
+func (c *awsSessionCache) ECR(account, region string) *ecr.ECR {
+	endpoint := c.config.ecrEndpoint(account, region)
+	key := region + " " + endpoint
+	s := c.session(key, region, endpoint)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[key]; ok {
+		return client
+	}
+	client := ecr.New(s)
+	c.clients[key] = client
+	return client
+}
+
+// STS returns the STS client for the default region
+func (c *awsSessionCache) STS() *sts.STS {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sts == nil {
+		c.sts = c.config.sts(c.creds)
+	}
+	return c.sts
+}
+
+// callerIdentity is the output of sts:GetCallerIdentity, which the vendored
+// SDK predates
+type callerIdentity struct {
+	_ struct{} `type:"structure"`
+
+	Account *string `type:"string"`
+	Arn     *string `type:"string"`
+	UserId  *string `type:"string"`
+}
+
+type getCallerIdentityInput struct {
+	_ struct{} `type:"structure"`
+}
+
+// getCallerIdentity returns the account and ARN of the credentials svc signs
+// with
+func getCallerIdentity(svc *sts.STS) (*callerIdentity, error) {
+	op := &request.Operation{
+		Name:       "GetCallerIdentity",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	out := &callerIdentity{}
+	err := svc.NewRequest(op, &getCallerIdentityInput{}, out).Send()
+	return out, err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessions_cachedPerRegion(t *testing.T) {
+	c := newAWSSessionCache(credentials.NewStaticCredentials("AKIAEXAMPLE", "secret", ""), awsClientConfig{})
+
+	assert.True(t, c.ECR("", "us-east-1") == c.ECR("", "us-east-1"))
+	assert.False(t, c.ECR("", "us-east-1") == c.ECR("", "eu-west-1"))
+	assert.Equal(t, "eu-west-1", aws.StringValue(c.ECR("", "eu-west-1").Config.Region))
+	// only the classified loop in getAuthorizationToken retries ECR calls
+	assert.Equal(t, 0, c.ECR("", "eu-west-1").MaxRetries())
+	assert.True(t, c.STS() == c.STS())
+}
+
+func TestSessions_getCallerIdentity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		assert.Contains(t, string(b), "Action=GetCallerIdentity")
+		w.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:sts::012345678910:assumed-role/updater/session</Arn>
+    <UserId>AROAEXAMPLE:session</UserId>
+    <Account>012345678910</Account>
+  </GetCallerIdentityResult>
+  <ResponseMetadata><RequestId>01234567-89ab-cdef-0123-456789abcdef</RequestId></ResponseMetadata>
+</GetCallerIdentityResponse>`))
+	}))
+	defer server.Close()
+	svc := sts.New(session.New(aws.NewConfig().
+		WithEndpoint(server.URL).
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("AKIAEXAMPLE", "secret", ""))))
+
+	id, err := getCallerIdentity(svc)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "012345678910", aws.StringValue(id.Account))
+	assert.Equal(t, "arn:aws:sts::012345678910:assumed-role/updater/session", aws.StringValue(id.Arn))
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+// maxListPages bounds how many pages are followed for a single listing, in
+// case a misbehaving server keeps returning next links
+const maxListPages = 1000
+
+// registryCache holds the Rancher registry and credential listings for a
+// single reconcile cycle so they are only fetched once. It is safe for use by
+// concurrent workers.
+type registryCache struct {
+	registryClient           client.RegistryOperations
+	registryCredentialClient client.RegistryCredentialOperations
+
+	mu          sync.Mutex
+	loadMu      sync.Mutex
+	registries  []client.Registry
+	loaded      bool
+	credentials map[string][]client.RegistryCredential
+	hosts       map[string]*sync.Mutex
+}
+
+func newRegistryCache(
+	registryClient client.RegistryOperations,
+	registryCredentialClient client.RegistryCredentialOperations) *registryCache {
+	return &registryCache{
+		registryClient:           registryClient,
+		registryCredentialClient: registryCredentialClient,
+		credentials:              map[string][]client.RegistryCredential{},
+		hosts:                    map[string]*sync.Mutex{},
+	}
+}
+
+// LockHost serialises work on a single registry host and returns the function
+// that releases it
+func (c *registryCache) LockHost(host string) func() {
+	c.mu.Lock()
+	lock, ok := c.hosts[host]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.hosts[host] = lock
+	}
+	c.mu.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// Registries returns every registry in the environment, fetching all pages on
+// first use
+func (c *registryCache) Registries() ([]client.Registry, error) {
+	c.loadMu.Lock()
+	defer c.loadMu.Unlock()
+	if !c.loaded {
+		registries, err := listRegistries(c.registryClient, &client.ListOpts{})
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.registries = append(registries, c.registries...)
+		c.loaded = true
+		c.mu.Unlock()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]client.Registry(nil), c.registries...), nil
+}
+
+// Credentials returns the credentials for a registry, filtered server side
+// by registry ID
+func (c *registryCache) Credentials(registryID string) ([]client.RegistryCredential, error) {
+	c.mu.Lock()
+	credentials, ok := c.credentials[registryID]
+	c.mu.Unlock()
+	if ok {
+		return credentials, nil
+	}
+	credentials, err := listRegistryCredentials(c.registryCredentialClient, &client.ListOpts{
+		Filters: map[string]interface{}{
+			"registryId": registryID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.SetCredentials(registryID, credentials)
+	return credentials, nil
+}
+
+// AddRegistry records a registry created during this cycle
+func (c *registryCache) AddRegistry(registry client.Registry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registries = append(c.registries, registry)
+}
+
+// RemoveRegistry forgets a registry deleted during this cycle
+func (c *registryCache) RemoveRegistry(registryID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, registry := range c.registries {
+		if registry.Id == registryID {
+			c.registries = append(c.registries[:i], c.registries[i+1:]...)
+			break
+		}
+	}
+	delete(c.credentials, registryID)
+}
+
+// SetCredentials records the credentials of a registry changed during this cycle
+func (c *registryCache) SetCredentials(registryID string, credentials []client.RegistryCredential) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.credentials[registryID] = credentials
+}
+
+func listRegistries(registryClient client.RegistryOperations, opts *client.ListOpts) ([]client.Registry, error) {
+	var registries []client.Registry
+	err := listPages("Registry", opts, func(opts *client.ListOpts) (*client.Pagination, error) {
+		collection, err := registryClient.List(opts)
+		if err != nil {
+			return nil, err
+		}
+		registries = append(registries, collection.Data...)
+		return collection.Pagination, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return registries, nil
+}
+
+func listRegistryCredentials(registryCredentialClient client.RegistryCredentialOperations, opts *client.ListOpts) ([]client.RegistryCredential, error) {
+	var credentials []client.RegistryCredential
+	err := listPages("Registry credential", opts, func(opts *client.ListOpts) (*client.Pagination, error) {
+		collection, err := registryCredentialClient.List(opts)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, collection.Data...)
+		return collection.Pagination, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func listServices(serviceClient client.ServiceOperations, opts *client.ListOpts) ([]client.Service, error) {
+	var services []client.Service
+	err := listPages("Service", opts, func(opts *client.ListOpts) (*client.Pagination, error) {
+		collection, err := serviceClient.List(opts)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, collection.Data...)
+		return collection.Pagination, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+func listContainers(containerClient client.ContainerOperations, opts *client.ListOpts) ([]client.Container, error) {
+	var containers []client.Container
+	err := listPages("Container", opts, func(opts *client.ListOpts) (*client.Pagination, error) {
+		collection, err := containerClient.List(opts)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, collection.Data...)
+		return collection.Pagination, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// listPages calls list with opts and then with the options of each following
+// page, up to maxListPages. list returns the pagination of the page it
+// fetched.
+func listPages(kind string, opts *client.ListOpts, list func(*client.ListOpts) (*client.Pagination, error)) error {
+	for page := 0; page < maxListPages; page++ {
+		pagination, err := list(opts)
+		if err != nil {
+			return err
+		}
+		if opts, err = nextPage(opts, pagination); err != nil || opts == nil {
+			return err
+		}
+	}
+	return fmt.Errorf("%s listing exceeded %d pages", kind, maxListPages)
+}
+
+// nextPage turns the next link of a collection into list options for the
+// following request, keeping the original filters. It returns nil when there
+// are no more pages.
+func nextPage(opts *client.ListOpts, pagination *client.Pagination) (*client.ListOpts, error) {
+	if pagination == nil || pagination.Next == "" {
+		return nil, nil
+	}
+	next, err := url.Parse(pagination.Next)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse next page link %s: %s", pagination.Next, err)
+	}
+	filters := map[string]interface{}{}
+	for k, v := range opts.Filters {
+		filters[k] = v
+	}
+	for k, v := range next.Query() {
+		if len(v) > 0 {
+			filters[k] = v[0]
+		}
+	}
+	return &client.ListOpts{Filters: filters}, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_pagination(t *testing.T) {
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(
+		&client.RegistryCollection{
+			Collection: client.Collection{
+				Pagination: &client.Pagination{
+					Next: "http://rancher/v1/registries?limit=1&marker=m2",
+				},
+			},
+			Data: []client.Registry{{Resource: client.Resource{Id: "1r1"}}},
+		}, nil).Once()
+	mockRegistry.On("List", &client.ListOpts{
+		Filters: map[string]interface{}{"limit": "1", "marker": "m2"},
+	}).Return(
+		&client.RegistryCollection{
+			Data: []client.Registry{{Resource: client.Resource{Id: "1r2"}}},
+		}, nil).Once()
+
+	cache := newRegistryCache(mockRegistry, new(mocks.RegistryCredentialOperations))
+	registries, err := cache.Registries()
+	assert.NoError(t, err)
+	assert.Len(t, registries, 2)
+	assert.Equal(t, "1r2", registries[1].Id)
+
+	// the second call is served from the cache
+	registries, err = cache.Registries()
+	assert.NoError(t, err)
+	assert.Len(t, registries, 2)
+	mockRegistry.AssertExpectations(t)
+}
+
+func TestCache_listedOncePerCycle(t *testing.T) {
+	r := &Rancher{ProxyHost: "registry.example.com"}
+	mockEcr := new(mocks.ECRAPI)
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	token := aws.String(base64.StdEncoding.EncodeToString([]byte("mockUser:mockPassword")))
+	mockEcr.On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{}).Return(
+		&ecr.GetAuthorizationTokenOutput{
+			AuthorizationData: []*ecr.AuthorizationData{
+				{ProxyEndpoint: aws.String("https://012345678910.dkr.ecr.us-east-1.amazonaws.com"), AuthorizationToken: token},
+				{ProxyEndpoint: aws.String("https://109876543210.dkr.ecr.us-east-1.amazonaws.com"), AuthorizationToken: token},
+			},
+		}, nil)
+	mockRegistry.On("List", &client.ListOpts{}).Return(
+		&client.RegistryCollection{
+			Data: []client.Registry{
+				{Resource: client.Resource{Id: "1r1"}, ServerAddress: "registry.example.com"},
+			},
+		}, nil).Once()
+	credential := client.RegistryCredential{Resource: client.Resource{Id: "1rc1"}, RegistryId: "1r1"}
+	mockRegistryCredential.On("List", &client.ListOpts{
+		Filters: map[string]interface{}{"registryId": "1r1"},
+	}).Return(&client.RegistryCredentialCollection{
+		Data: []client.RegistryCredential{credential},
+	}, nil).Once()
+	mockRegistryCredential.On("Update", &credential, &client.RegistryCredential{
+		PublicValue: "mockUser",
+		SecretValue: "mockPassword",
+		Email:       "not-really@required.anymore",
+	}).Return(&credential, nil).Twice()
+
+	r.updateEcr(context.Background(), mockEcr, mockRegistry, mockRegistryCredential)
+
+	mockEcr.AssertExpectations(t)
+	mockRegistry.AssertExpectations(t)
+	mockRegistryCredential.AssertExpectations(t)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// daemon runs update cycles on an interval until it receives SIGTERM or
+// SIGINT. SIGUSR1 or a value on refresh starts a cycle right away.
+type daemon struct {
+	Interval time.Duration
+	// InitialDelay postpones the first cycle, e.g. after a restart
+	InitialDelay time.Duration
+	// DrainTimeout bounds how long shutdown waits for a running cycle
+	DrainTimeout time.Duration
+	update       func(ctx context.Context)
+	refresh      <-chan struct{}
+	// shutdown is called once the running cycle has drained, e.g. to stop
+	// the health check listener
+	shutdown func(ctx context.Context)
+}
+
+// Run starts the first cycle after InitialDelay and returns after a shutdown
+// signal
+func (d *daemon) Run(ctx context.Context, signals <-chan os.Signal) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the ticker starts with the first scheduled cycle so later ones keep
+	// the interval from it
+	var ticker *time.Ticker
+	var ticks, first <-chan time.Time
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+	startTicker := func() {
+		ticker = time.NewTicker(d.Interval)
+		ticks = ticker.C
+	}
+
+	var running chan struct{}
+	// a refresh requested while a cycle is running starts another once it
+	// finishes, so a cycle that was skipped or began before the request is
+	// not taken as having served it
+	pending := false
+	start := func() {
+		if running != nil {
+			log.Info("Update cycle already running, refreshing again once it finishes")
+			pending = true
+			return
+		}
+		running = make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+			d.update(ctx)
+		}(running)
+	}
+
+	if d.InitialDelay > 0 {
+		log.Infof("Next update cycle due in %s", d.InitialDelay)
+		timer := time.NewTimer(d.InitialDelay)
+		defer timer.Stop()
+		first = timer.C
+	} else {
+		start()
+		startTicker()
+	}
+	for {
+		select {
+		case <-first:
+			first = nil
+			start()
+			startTicker()
+		case <-running:
+			running = nil
+			if pending {
+				pending = false
+				start()
+				continue
+			}
+			log.Debug("Sleeping until next poll cycle")
+		case <-ticks:
+			start()
+		case <-d.refresh:
+			start()
+		case sig := <-signals:
+			if sig == syscall.SIGUSR1 {
+				log.Info("Received SIGUSR1, refreshing credentials now")
+				start()
+				continue
+			}
+			log.Infof("Received %s, shutting down", sig)
+			cancel()
+			d.drain(running)
+			return
+		case <-ctx.Done():
+			d.drain(running)
+			return
+		}
+	}
+}
+
+// drain waits up to DrainTimeout for a running cycle, then calls shutdown
+// with the time that is left
+func (d *daemon) drain(running chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.DrainTimeout)
+	defer cancel()
+	if running != nil {
+		select {
+		case <-running:
+		case <-ctx.Done():
+			log.Warnf("Update cycle did not finish within %s", d.DrainTimeout)
+		}
+	}
+	if d.shutdown != nil {
+		d.shutdown(ctx)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDaemon_refreshAndShutdown(t *testing.T) {
+	var cycles int32
+	cycleDone := make(chan struct{}, 10)
+	var shutdownCalled bool
+	d := &daemon{
+		Interval:     time.Hour,
+		DrainTimeout: time.Second,
+		update: func(ctx context.Context) {
+			atomic.AddInt32(&cycles, 1)
+			cycleDone <- struct{}{}
+		},
+		shutdown: func(ctx context.Context) {
+			shutdownCalled = true
+		},
+	}
+	signals := make(chan os.Signal)
+	stopped := make(chan struct{})
+	go func() {
+		d.Run(context.Background(), signals)
+		close(stopped)
+	}()
+
+	<-cycleDone
+	signals <- syscall.SIGUSR1
+	<-cycleDone
+	assert.Equal(t, int32(2), atomic.LoadInt32(&cycles))
+
+	signals <- syscall.SIGTERM
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("daemon did not stop after SIGTERM")
+	}
+	assert.True(t, shutdownCalled)
+}
+
+func TestDaemon_drainsRunningCycle(t *testing.T) {
+	var finished int32
+	started := make(chan struct{})
+	d := &daemon{
+		Interval:     time.Hour,
+		DrainTimeout: time.Second,
+		update: func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+			// an in-flight Rancher call completing after the signal
+			time.Sleep(10 * time.Millisecond)
+			atomic.StoreInt32(&finished, 1)
+		},
+	}
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	go func() {
+		d.Run(context.Background(), signals)
+		close(stopped)
+	}()
+
+	<-started
+	signals <- syscall.SIGINT
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("daemon did not stop after SIGINT")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&finished), "shutdown should wait for the running cycle")
+}
+
+func TestDaemon_initialDelay(t *testing.T) {
+	started := make(chan time.Time, 1)
+	d := &daemon{
+		Interval:     time.Hour,
+		InitialDelay: 50 * time.Millisecond,
+		DrainTimeout: time.Second,
+		update: func(ctx context.Context) {
+			started <- time.Now()
+		},
+	}
+	signals := make(chan os.Signal, 1)
+	begin := time.Now()
+	go d.Run(context.Background(), signals)
+
+	select {
+	case at := <-started:
+		assert.True(t, at.Sub(begin) >= 50*time.Millisecond, "first cycle should wait for InitialDelay")
+	case <-time.After(time.Second):
+		t.Fatal("first cycle did not start")
+	}
+	signals <- syscall.SIGTERM
+}
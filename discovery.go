@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/rancher/go-rancher/client"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_discovered_registries", "gauge", "ECR registries found in the images used by services and containers.")
+}
+
+// discovery finds the ECR registries referenced by the images of the
+// environment's services and containers
+type discovery struct {
+	// Allow, if not empty, lists the only AWS accounts to manage
+	Allow []string
+	// Deny lists AWS accounts never to manage, even if allowed
+	Deny []string
+
+	serviceClient   client.ServiceOperations
+	containerClient client.ContainerOperations
+	// ecrClient returns the ECR client for an account in a region. Without it
+	// every region is requested through the default client.
+	ecrClient func(account, region string) ecriface.ECRAPI
+}
+
+// Allowed applies the allow and deny lists to an AWS account
+func (d *discovery) Allowed(account string) bool {
+	for _, denied := range d.Deny {
+		if denied == account {
+			return false
+		}
+	}
+	if len(d.Allow) == 0 {
+		return true
+	}
+	for _, allowed := range d.Allow {
+		if allowed == account {
+			return true
+		}
+	}
+	return false
+}
+
+// Discover returns the allowed AWS account IDs referenced by images, by region
+func (d *discovery) Discover() (map[string][]string, error) {
+	images, err := serviceImages(d.serviceClient)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := listContainers(d.containerClient, &client.ListOpts{})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list containers: %s", err)
+	}
+	for _, container := range containers {
+		if container.State != "removed" && container.State != "purged" {
+			images = append(images, container.ImageUuid)
+		}
+	}
+
+	seen := map[ecrHostInfo]bool{}
+	accounts := map[string][]string{}
+	for _, image := range images {
+		info := parseEcrHost(imageHost(image))
+		if info.Account == "" || seen[info] {
+			continue
+		}
+		seen[info] = true
+		if !d.Allowed(info.Account) {
+			log.Debugf("[discovery] Ignoring registry of account %s in %s", info.Account, info.Region)
+			continue
+		}
+		accounts[info.Region] = append(accounts[info.Region], info.Account)
+	}
+	count := 0
+	for _, ids := range accounts {
+		sort.Strings(ids)
+		count += len(ids)
+	}
+	metrics.Set("ecr_updater_discovered_registries", float64(count))
+	return accounts, nil
+}
+
+// discoverAuthorizationData requests tokens for the registries found by
+// Discovery, one request per region and endpoint
+func (r *Rancher) discoverAuthorizationData(ctx context.Context, svc ecriface.ECRAPI) ([]*ecr.AuthorizationData, []providerFailure) {
+	accounts, err := r.Discovery.Discover()
+	if err != nil {
+		log.Printf("[discovery] %s\n", err)
+		return nil, []providerFailure{{Class: errClassOther, Error: err.Error()}}
+	}
+	regions := make([]string, 0, len(accounts))
+	for region := range accounts {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	var data []*ecr.AuthorizationData
+	var failures []providerFailure
+	for _, region := range regions {
+		log.Printf("[discovery] Found registries %s in %s\n", strings.Join(accounts[region], ","), region)
+		regionData, regionFailures := r.getAuthorizationDataByClient(ctx, svc, r.Discovery.ecrClient, region, accounts[region])
+		data = append(data, regionData...)
+		failures = append(failures, regionFailures...)
+	}
+	return data, failures
+}
+
+// serviceImages returns the images of every service's launch configs,
+// including sidekicks
+func serviceImages(serviceClient client.ServiceOperations) ([]string, error) {
+	services, err := listServices(serviceClient, &client.ListOpts{})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list services: %s", err)
+	}
+	var images []string
+	for _, service := range services {
+		if service.LaunchConfig != nil {
+			images = append(images, service.LaunchConfig.ImageUuid)
+		}
+		for _, secondary := range service.SecondaryLaunchConfigs {
+			if config, ok := secondary.(map[string]interface{}); ok {
+				if image, ok := config["imageUuid"].(string); ok {
+					images = append(images, image)
+				}
+			}
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockImages() (*mocks.ServiceOperations, *mocks.ContainerOperations) {
+	mockServices := new(mocks.ServiceOperations)
+	mockContainers := new(mocks.ContainerOperations)
+	mockServices.On("List", &client.ListOpts{}).Return(&client.ServiceCollection{Data: []client.Service{
+		{
+			LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:012345678910.dkr.ecr.us-east-1.amazonaws.com/app:1"},
+			SecondaryLaunchConfigs: []interface{}{
+				map[string]interface{}{"imageUuid": "docker:109876543210.dkr.ecr.eu-west-1.amazonaws.com/sidecar:2"},
+			},
+		},
+		{LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:nginx:latest"}},
+	}}, nil)
+	mockContainers.On("List", &client.ListOpts{}).Return(&client.ContainerCollection{Data: []client.Container{
+		{ImageUuid: "docker:012345678910.dkr.ecr.us-east-1.amazonaws.com/job:3"},
+		{ImageUuid: "docker:555555555555.dkr.ecr.us-east-1.amazonaws.com/job:3"},
+		{ImageUuid: "docker:666666666666.dkr.ecr.us-east-1.amazonaws.com/old:1", State: "removed"},
+	}}, nil)
+	return mockServices, mockContainers
+}
+
+func TestDiscovery_findsRegistries(t *testing.T) {
+	mockServices, mockContainers := mockImages()
+	d := &discovery{serviceClient: mockServices, containerClient: mockContainers}
+
+	accounts, err := d.Discover()
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"us-east-1": {"012345678910", "555555555555"},
+		"eu-west-1": {"109876543210"},
+	}, accounts)
+}
+
+func TestDiscovery_allowAndDeny(t *testing.T) {
+	mockServices, mockContainers := mockImages()
+	d := &discovery{
+		Allow:           []string{"012345678910", "555555555555"},
+		Deny:            []string{"555555555555"},
+		serviceClient:   mockServices,
+		containerClient: mockContainers,
+	}
+
+	accounts, err := d.Discover()
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"us-east-1": {"012345678910"}}, accounts)
+}
+
+func TestDiscovery_requestsEachRegion(t *testing.T) {
+	mockServices, mockContainers := mockImages()
+	clients := map[string]*mocks.ECRAPI{"us-east-1": new(mocks.ECRAPI), "eu-west-1": new(mocks.ECRAPI)}
+	clients["us-east-1"].On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{
+		RegistryIds: aws.StringSlice([]string{"012345678910", "555555555555"}),
+	}).Return(&ecr.GetAuthorizationTokenOutput{AuthorizationData: []*ecr.AuthorizationData{{}, {}}}, nil)
+	clients["eu-west-1"].On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{
+		RegistryIds: aws.StringSlice([]string{"109876543210"}),
+	}).Return(&ecr.GetAuthorizationTokenOutput{AuthorizationData: []*ecr.AuthorizationData{{}}}, nil)
+	r := &Rancher{Discovery: &discovery{
+		serviceClient:   mockServices,
+		containerClient: mockContainers,
+		ecrClient:       func(account, region string) ecriface.ECRAPI { return clients[region] },
+	}}
+
+	data, failures := r.discoverAuthorizationData(context.Background(), nil)
+
+	assert.Len(t, data, 3)
+	assert.Empty(t, failures)
+	clients["us-east-1"].AssertExpectations(t)
+	clients["eu-west-1"].AssertExpectations(t)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_aws_errors_total", "counter", "Failed GetAuthorizationToken calls by class of error.")
+	metrics.Describe("ecr_updater_aws_retries_total", "counter", "GetAuthorizationToken calls retried after throttling or a server error.")
+	metrics.Describe("ecr_updater_aws_call_errors_total", "counter", "Failed AWS calls other than GetAuthorizationToken, by operation and class of error.")
+}
+
+// classifyAwsError maps an error returned by the AWS SDK to one of the
+// errClass classes
+func classifyAwsError(err error) (class, code string) {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return errClassOther, ""
+	}
+	code = aerr.Code()
+	switch code {
+	case "Throttling", "ThrottlingException", "ThrottledException", "RequestThrottled",
+		"RequestLimitExceeded", "TooManyRequestsException", "ProvisionedThroughputExceededException":
+		return errClassThrottling, code
+	case "ServerException", "InternalFailure", "ServiceUnavailable", "ServiceUnavailableException", "RequestTimeout":
+		return errClassServer, code
+	case "ExpiredToken", "ExpiredTokenException", "InvalidClientTokenId", "UnrecognizedClientException",
+		"SignatureDoesNotMatch", "IncompleteSignature", "NoCredentialProviders", "MissingAuthenticationToken":
+		return errClassCredentials, code
+	case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation":
+		return errClassAccessDenied, code
+	case "InvalidParameterException", "RepositoryNotFoundException", "RegistryNotFoundException":
+		return errClassInvalidRegistry, code
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= 500 {
+		return errClassServer, code
+	}
+	return errClassOther, code
+}
+
+// getAuthorizationData requests tokens for the given registry IDs. When
+// a request for several IDs fails because of one of them, each ID is requested
+// on its own so the others are still updated.
+func (r *Rancher) getAuthorizationData(ctx context.Context, svc ecriface.ECRAPI, registryIds []string) ([]*ecr.AuthorizationData, []providerFailure) {
+	data, err := r.getAuthorizationToken(ctx, svc, registryIds)
+	if err == nil {
+		return data, nil
+	}
+	class, _ := classifyAwsError(err)
+	if len(registryIds) < 2 || (class != errClassAccessDenied && class != errClassInvalidRegistry) {
+		registryID := ""
+		if len(registryIds) == 1 {
+			registryID = registryIds[0]
+		}
+		return nil, []providerFailure{newAwsFailure(registryID, err)}
+	}
+
+	log.Printf("Request for %d registries failed, requesting each registry separately\n", len(registryIds))
+	var failures []providerFailure
+	for _, id := range registryIds {
+		if ctx.Err() != nil {
+			failures = append(failures, newAwsFailure(id, ctx.Err()))
+			continue
+		}
+		idData, err := r.getAuthorizationToken(ctx, svc, []string{id})
+		if err != nil {
+			failures = append(failures, newAwsFailure(id, err))
+			continue
+		}
+		data = append(data, idData...)
+	}
+	return data, failures
+}
+
+// getAuthorizationDataByClient requests tokens for the registry IDs of a
+// region, one request per ECR client, so registries with an endpoint of their
+// own are reached through it. Without ecrClient every ID goes through svc.
+func (r *Rancher) getAuthorizationDataByClient(ctx context.Context, svc ecriface.ECRAPI, ecrClient func(account, region string) ecriface.ECRAPI, region string, registryIds []string) ([]*ecr.AuthorizationData, []providerFailure) {
+	if ecrClient == nil || len(registryIds) == 0 {
+		return r.getAuthorizationData(ctx, svc, registryIds)
+	}
+	var clients []ecriface.ECRAPI
+	ids := map[ecriface.ECRAPI][]string{}
+	for _, id := range registryIds {
+		client := ecrClient(id, region)
+		if _, ok := ids[client]; !ok {
+			clients = append(clients, client)
+		}
+		ids[client] = append(ids[client], id)
+	}
+	var data []*ecr.AuthorizationData
+	var failures []providerFailure
+	for _, client := range clients {
+		clientData, clientFailures := r.getAuthorizationData(ctx, client, ids[client])
+		data = append(data, clientData...)
+		failures = append(failures, clientFailures...)
+	}
+	return data, failures
+}
+
+// getAuthorizationToken calls GetAuthorizationToken, retrying throttling and
+// server errors with backoff
+func (r *Rancher) getAuthorizationToken(ctx context.Context, svc ecriface.ECRAPI, registryIds []string) ([]*ecr.AuthorizationData, error) {
+	request := &ecr.GetAuthorizationTokenInput{}
+	if len(registryIds) > 0 {
+		request = &ecr.GetAuthorizationTokenInput{RegistryIds: aws.StringSlice(registryIds)}
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := svc.GetAuthorizationToken(request)
+		log.Debug(resp)
+		if err == nil {
+			return resp.AuthorizationData, nil
+		}
+		class, _ := classifyAwsError(err)
+		if (class != errClassThrottling && class != errClassServer) || r.AWSBackoff == nil || attempt >= r.AWSBackoff.Attempts {
+			return nil, err
+		}
+		metrics.Inc("ecr_updater_aws_retries_total", "class", class)
+		log.WithField("class", class).Debugf("Retrying AWS GetAuthorizationToken after attempt %d failed: %s", attempt, err)
+		if r.AWSBackoff.Wait(ctx, attempt, class == errClassThrottling) != nil {
+			return nil, err
+		}
+	}
+}
+
+func newAwsFailure(registryID string, err error) providerFailure {
+	class, code := classifyAwsError(err)
+	metrics.Inc("ecr_updater_aws_errors_total", "class", class)
+	log.WithFields(log.Fields{
+		"class":      class,
+		"registryId": registryID,
+	}).Errorf("Error calling AWS API: %s", err)
+	return providerFailure{
+		RegistryID: registryID,
+		Class:      class,
+		Code:       code,
+		Error:      err.Error(),
+	}
+}
+
+// newAwsCallFailure logs and counts a failed AWS call made while managing
+// repositories, policies, audits or the inventory, separately from the
+// GetAuthorizationToken failures
+func newAwsCallFailure(operation, registryID string, err error) providerFailure {
+	class, code := classifyAwsError(err)
+	metrics.Inc("ecr_updater_aws_call_errors_total", "operation", operation, "class", class)
+	log.WithFields(log.Fields{
+		"class":      class,
+		"operation":  operation,
+		"registryId": registryID,
+	}).Errorf("Error calling AWS API: %s", err)
+	return providerFailure{
+		RegistryID: registryID,
+		Class:      class,
+		Code:       code,
+		Error:      err.Error(),
+	}
+}
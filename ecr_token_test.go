@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEcrToken_classifyAwsError(t *testing.T) {
+	class, code := classifyAwsError(awserr.New("ThrottlingException", "Rate exceeded", nil))
+	assert.Equal(t, errClassThrottling, class)
+	assert.Equal(t, "ThrottlingException", code)
+
+	class, _ = classifyAwsError(awserr.NewRequestFailure(awserr.New("Unknown", "", nil), 502, "req"))
+	assert.Equal(t, errClassServer, class)
+
+	class, _ = classifyAwsError(awserr.New("ExpiredTokenException", "", nil))
+	assert.Equal(t, errClassCredentials, class)
+
+	class, _ = classifyAwsError(awserr.New("AccessDeniedException", "", nil))
+	assert.Equal(t, errClassAccessDenied, class)
+
+	class, _ = classifyAwsError(awserr.New("InvalidParameterException", "", nil))
+	assert.Equal(t, errClassInvalidRegistry, class)
+
+	class, _ = classifyAwsError(errors.New("boom"))
+	assert.Equal(t, errClassOther, class)
+}
+
+func TestEcrToken_retriesThrottling(t *testing.T) {
+	r := &Rancher{AWSBackoff: newBackoff(3, time.Millisecond)}
+	r.AWSBackoff.sleep = func(time.Duration) {}
+	mockEcr := new(mocks.ECRAPI)
+	mockEcr.On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{}).Return(
+		nil, awserr.New("ThrottlingException", "Rate exceeded", nil)).Once()
+	mockEcr.On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{}).Return(
+		&ecr.GetAuthorizationTokenOutput{
+			AuthorizationData: []*ecr.AuthorizationData{{ProxyEndpoint: aws.String("https://012345678910.dkr.ecr.us-east-1.amazonaws.com")}},
+		}, nil).Once()
+
+	data, failures := r.getAuthorizationData(context.Background(), mockEcr, r.RegistryIds)
+
+	assert.Len(t, data, 1)
+	assert.Empty(t, failures)
+	mockEcr.AssertExpectations(t)
+}
+
+func TestEcrToken_credentialErrorsNotRetried(t *testing.T) {
+	r := &Rancher{AWSBackoff: newBackoff(3, time.Millisecond)}
+	mockEcr := new(mocks.ECRAPI)
+	mockEcr.On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{}).Return(
+		nil, awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil)).Once()
+	before := metrics.Value("ecr_updater_aws_errors_total", "class", errClassCredentials)
+
+	rep := r.updateEcr(context.Background(), mockEcr, new(mocks.RegistryOperations), new(mocks.RegistryCredentialOperations))
+
+	mockEcr.AssertExpectations(t)
+	assert.NotEmpty(t, rep.Error)
+	assert.Equal(t, []providerFailure{{
+		Class: errClassCredentials,
+		Code:  "ExpiredTokenException",
+		Error: "ExpiredTokenException: The security token included in the request is expired",
+	}}, rep.ProviderFailures)
+	assert.Equal(t, before+1, metrics.Value("ecr_updater_aws_errors_total", "class", errClassCredentials))
+}
+
+func TestEcrToken_fallsBackToSingleRegistryRequests(t *testing.T) {
+	r := &Rancher{RegistryIds: []string{"012345678910", "109876543210"}}
+	mockEcr := new(mocks.ECRAPI)
+	denied := awserr.New("AccessDeniedException", "not authorized", nil)
+	mockEcr.On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{
+		RegistryIds: aws.StringSlice([]string{"012345678910", "109876543210"}),
+	}).Return(nil, denied)
+	mockEcr.On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{
+		RegistryIds: aws.StringSlice([]string{"012345678910"}),
+	}).Return(nil, denied)
+	mockEcr.On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{
+		RegistryIds: aws.StringSlice([]string{"109876543210"}),
+	}).Return(&ecr.GetAuthorizationTokenOutput{
+		AuthorizationData: []*ecr.AuthorizationData{{
+			ProxyEndpoint:      aws.String("https://109876543210.dkr.ecr.us-east-1.amazonaws.com"),
+			AuthorizationToken: aws.String(base64.StdEncoding.EncodeToString([]byte("mockUser:mockPassword"))),
+		}},
+	}, nil)
+
+	data, failures := r.getAuthorizationData(context.Background(), mockEcr, r.RegistryIds)
+
+	mockEcr.AssertExpectations(t)
+	assert.Len(t, data, 1)
+	assert.Equal(t, "https://109876543210.dkr.ecr.us-east-1.amazonaws.com", *data[0].ProxyEndpoint)
+	assert.Len(t, failures, 1)
+	assert.Equal(t, "012345678910", failures[0].RegistryID)
+	assert.Equal(t, errClassAccessDenied, failures[0].Class)
+}
+
+func TestEcrToken_requestsEachEndpoint(t *testing.T) {
+	r := &Rancher{}
+	shared := new(mocks.ECRAPI)
+	shared.On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{
+		RegistryIds: aws.StringSlice([]string{"012345678910", "555555555555"}),
+	}).Return(&ecr.GetAuthorizationTokenOutput{AuthorizationData: []*ecr.AuthorizationData{{}, {}}}, nil)
+	partner := new(mocks.ECRAPI)
+	partner.On("GetAuthorizationToken", &ecr.GetAuthorizationTokenInput{
+		RegistryIds: aws.StringSlice([]string{"109876543210"}),
+	}).Return(&ecr.GetAuthorizationTokenOutput{AuthorizationData: []*ecr.AuthorizationData{{}}}, nil)
+	ecrClient := func(account, region string) ecriface.ECRAPI {
+		assert.Equal(t, "", region)
+		if account == "109876543210" {
+			return partner
+		}
+		return shared
+	}
+
+	data, failures := r.getAuthorizationDataByClient(context.Background(), nil, ecrClient, "",
+		[]string{"012345678910", "109876543210", "555555555555"})
+
+	assert.Len(t, data, 3)
+	assert.Empty(t, failures)
+	shared.AssertExpectations(t)
+	partner.AssertExpectations(t)
+}
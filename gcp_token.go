@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	gcpDefaultTokenURI = "https://oauth2.googleapis.com/token"
+	gcpScope           = "https://www.googleapis.com/auth/cloud-platform"
+	// gcpUsername is the Docker username that goes with an OAuth access token
+	gcpUsername = "oauth2accesstoken"
+)
+
+// gcpServiceAccount is the part of a service account JSON key the exchange
+// needs
+type gcpServiceAccount struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// gcpProvider exchanges a JWT signed with a service account key for an
+// access token, and uses it to log in to Google Artifact Registry and
+// Container Registry hosts
+type gcpProvider struct {
+	// Registries are the hosts to log in to, e.g. us-docker.pkg.dev
+	Registries []string
+	account    gcpServiceAccount
+	key        *rsa.PrivateKey
+	httpClient *http.Client
+}
+
+// newGcpProvider loads a service account key file
+func newGcpProvider(keyFile string, registries []string) (*gcpProvider, error) {
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	p := &gcpProvider{Registries: registries, httpClient: &http.Client{Timeout: 30 * time.Second}}
+	if err := json.Unmarshal(b, &p.account); err != nil {
+		return nil, fmt.Errorf("Unable to parse service account key %s: %s", keyFile, err)
+	}
+	if p.account.TokenURI == "" {
+		p.account.TokenURI = gcpDefaultTokenURI
+	}
+	if p.key, err = parseRSAKey(p.account.PrivateKey); err != nil {
+		return nil, fmt.Errorf("Unable to parse private key in %s: %s", keyFile, err)
+	}
+	return p, nil
+}
+
+func (p *gcpProvider) Name() string {
+	return "gcp"
+}
+
+func (p *gcpProvider) Tokens(ctx context.Context) ([]registryAuth, []providerFailure) {
+	token, expiresAt, err := p.accessToken(ctx)
+	if err != nil {
+		var failures []providerFailure
+		for _, registry := range p.Registries {
+			failures = append(failures, newProviderFailure(p.Name(), registry, err))
+		}
+		return nil, failures
+	}
+	var tokens []registryAuth
+	for _, registry := range p.Registries {
+		tokens = append(tokens, registryAuth{
+			Endpoint:  "https://" + registry,
+			Auth:      basicAuth(gcpUsername, token),
+			ExpiresAt: &expiresAt,
+		})
+	}
+	return tokens, nil
+}
+
+// accessToken performs the JWT bearer grant
+func (p *gcpProvider) accessToken(ctx context.Context) (string, time.Time, error) {
+	issued := now()
+	assertion, err := p.signJWT(map[string]interface{}{
+		"iss":   p.account.ClientEmail,
+		"scope": gcpScope,
+		"aud":   p.account.TokenURI,
+		"iat":   issued.Unix(),
+		"exp":   issued.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = postForm(ctx, p.httpClient, p.account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}, &resp)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if resp.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("No access token in response from %s", p.account.TokenURI)
+	}
+	return resp.AccessToken, issued.Add(time.Duration(resp.ExpiresIn) * time.Second), nil
+}
+
+// signJWT encodes claims as an RS256 JSON Web Token
+func (p *gcpProvider) signJWT(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.account.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseRSAKey reads a PEM encoded PKCS#8 or PKCS#1 RSA private key
+func parseRSAKey(key string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found")
+	}
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("Private key is not an RSA key")
+		}
+		return rsaKey, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
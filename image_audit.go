@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/rancher/go-rancher/client"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_images_checked", "gauge", "ECR images referenced by services in the last image audit.")
+	metrics.Describe("ecr_updater_images_missing", "gauge", "ECR images referenced by services that do not exist.")
+}
+
+// batchGetImageLimit is the most image IDs BatchGetImage accepts at once
+const batchGetImageLimit = 100
+
+// missingImage is an image referenced by services that ECR does not have
+type missingImage struct {
+	Image    string   `json:"image"`
+	Services []string `json:"services"`
+	Code     string   `json:"code"`
+	Reason   string   `json:"reason,omitempty"`
+}
+
+// auditReport is the outcome of one image audit
+type auditReport struct {
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Checked  int               `json:"checked"`
+	Missing  []missingImage    `json:"missing"`
+	Errors   []providerFailure `json:"errors,omitempty"`
+
+	// referenced holds every image used by a service, and is nil if the
+	// services could not be listed
+	referenced map[string]bool
+	// checked holds the images ECR was asked about; the others were skipped
+	// because of an error or cancellation
+	checked map[string]bool
+}
+
+// imageAudit periodically checks that every ECR image used by a service
+// exists, so a stack referencing a missing tag is noticed before it is
+// rolled out
+type imageAudit struct {
+	Interval time.Duration
+
+	serviceClient client.ServiceOperations
+	ecrClient     func(account, region string) ecriface.ECRAPI
+	notify        *notifier
+}
+
+// imageRef is an ECR image and the services that use it
+type imageRef struct {
+	ecrImage
+	image    string
+	services []string
+}
+
+// Run audits every Interval while active returns true, until ctx is done
+func (a *imageAudit) Run(ctx context.Context, active func() bool) {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		if active() {
+			rep := a.Audit(ctx)
+			audits.Set(rep)
+			a.notify.ImagesMissing(rep)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Audit resolves every ECR image used by a service
+func (a *imageAudit) Audit(ctx context.Context) *auditReport {
+	rep := &auditReport{Started: now(), Missing: []missingImage{}, checked: map[string]bool{}}
+	refs, err := a.serviceImageRefs()
+	if err != nil {
+		log.Printf("[audit] %s\n", err)
+		rep.Errors = append(rep.Errors, providerFailure{Class: errClassOther, Error: err.Error()})
+		rep.Finished = now()
+		return rep
+	}
+
+	// one BatchGetImage call per repository, in chunks
+	rep.referenced = map[string]bool{}
+	byRepository := map[ecrHostInfo]map[string][]*imageRef{}
+	for _, ref := range refs {
+		rep.referenced[ref.image] = true
+		if byRepository[ref.Info] == nil {
+			byRepository[ref.Info] = map[string][]*imageRef{}
+		}
+		byRepository[ref.Info][ref.Repository] = append(byRepository[ref.Info][ref.Repository], ref)
+	}
+	for info, repositories := range byRepository {
+		svc := a.ecrClient(info.Account, info.Region)
+		for repository, images := range repositories {
+			for start := 0; start < len(images); start += batchGetImageLimit {
+				if ctx.Err() != nil {
+					rep.Finished = now()
+					return rep
+				}
+				end := start + batchGetImageLimit
+				if end > len(images) {
+					end = len(images)
+				}
+				missing, err := checkImages(svc, info.Account, repository, images[start:end])
+				if err != nil {
+					rep.Errors = append(rep.Errors, newAwsCallFailure("BatchGetImage", info.Account, err))
+					continue
+				}
+				for _, ref := range images[start:end] {
+					rep.checked[ref.image] = true
+				}
+				rep.Checked += end - start
+				rep.Missing = append(rep.Missing, missing...)
+			}
+		}
+	}
+	sort.Slice(rep.Missing, func(i, j int) bool { return rep.Missing[i].Image < rep.Missing[j].Image })
+	for _, m := range rep.Missing {
+		log.Warnf("[audit] Image %s used by %s is missing: %s", m.Image, strings.Join(m.Services, ", "), m.Code)
+	}
+	metrics.Set("ecr_updater_images_checked", float64(rep.Checked))
+	metrics.Set("ecr_updater_images_missing", float64(len(rep.Missing)))
+	rep.Finished = now()
+	log.Printf("[audit] Checked %d images, %d missing\n", rep.Checked, len(rep.Missing))
+	return rep
+}
+
+// serviceImageRefs lists the distinct ECR images used by services
+func (a *imageAudit) serviceImageRefs() ([]*imageRef, error) {
+	services, err := listServices(a.serviceClient, &client.ListOpts{})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list services: %s", err)
+	}
+	refs := map[string]*imageRef{}
+	var ordered []*imageRef
+	add := func(image, service string) {
+		parsed, ok := parseEcrImage(image)
+		if !ok {
+			return
+		}
+		ref, ok := refs[image]
+		if !ok {
+			ref = &imageRef{ecrImage: parsed, image: image}
+			refs[image] = ref
+			ordered = append(ordered, ref)
+		}
+		ref.services = append(ref.services, service)
+	}
+	for _, service := range services {
+		if service.LaunchConfig != nil {
+			add(service.LaunchConfig.ImageUuid, service.Name)
+		}
+		for _, secondary := range service.SecondaryLaunchConfigs {
+			if config, ok := secondary.(map[string]interface{}); ok {
+				if image, ok := config["imageUuid"].(string); ok {
+					add(image, service.Name)
+				}
+			}
+		}
+	}
+	return ordered, nil
+}
+
+// checkImages calls BatchGetImage for images of one repository and returns
+// the missing ones
+func checkImages(svc ecriface.ECRAPI, registryID, repository string, images []*imageRef) ([]missingImage, error) {
+	input := &ecr.BatchGetImageInput{
+		RegistryId:     aws.String(registryID),
+		RepositoryName: aws.String(repository),
+	}
+	for _, ref := range images {
+		id := &ecr.ImageIdentifier{}
+		if ref.Digest != "" {
+			id.ImageDigest = aws.String(ref.Digest)
+		} else {
+			id.ImageTag = aws.String(ref.Tag)
+		}
+		input.ImageIds = append(input.ImageIds, id)
+	}
+	resp, err := svc.BatchGetImage(input)
+	if err != nil {
+		if _, code := classifyAwsError(err); code == "RepositoryNotFoundException" {
+			var missing []missingImage
+			for _, ref := range images {
+				missing = append(missing, missingImage{Image: ref.image, Services: ref.services, Code: "RepositoryNotFound"})
+			}
+			return missing, nil
+		}
+		return nil, err
+	}
+	var missing []missingImage
+	for _, failure := range resp.Failures {
+		if failure.ImageId == nil {
+			continue
+		}
+		for _, ref := range images {
+			if (ref.Digest != "" && ref.Digest == aws.StringValue(failure.ImageId.ImageDigest)) ||
+				(ref.Digest == "" && ref.Tag == aws.StringValue(failure.ImageId.ImageTag)) {
+				missing = append(missing, missingImage{
+					Image:    ref.image,
+					Services: ref.services,
+					Code:     aws.StringValue(failure.FailureCode),
+					Reason:   aws.StringValue(failure.FailureReason),
+				})
+			}
+		}
+	}
+	return missing, nil
+}
+
+// audits serves the report of the most recent image audit on /images
+var audits = &jsonHolder{}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageAudit_reportsMissing(t *testing.T) {
+	mockServices := new(mocks.ServiceOperations)
+	mockServices.On("List", &client.ListOpts{}).Return(&client.ServiceCollection{Data: []client.Service{
+		{Name: "web", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/app:1"},
+			SecondaryLaunchConfigs: []interface{}{map[string]interface{}{"imageUuid": "docker:" + ecrHost + "/app:2"}}},
+		{Name: "worker", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/app:2"}},
+		{Name: "gone", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/removed@sha256:abc"}},
+		{Name: "hub", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:nginx:latest"}},
+	}}, nil)
+	mockEcr := new(mocks.ECRAPI)
+	mockEcr.On("BatchGetImage", &ecr.BatchGetImageInput{
+		RegistryId:     aws.String("012345678910"),
+		RepositoryName: aws.String("app"),
+		ImageIds:       []*ecr.ImageIdentifier{{ImageTag: aws.String("1")}, {ImageTag: aws.String("2")}},
+	}).Return(&ecr.BatchGetImageOutput{Failures: []*ecr.ImageFailure{{
+		ImageId:       &ecr.ImageIdentifier{ImageTag: aws.String("2")},
+		FailureCode:   aws.String("ImageNotFound"),
+		FailureReason: aws.String("Requested image not found"),
+	}}}, nil)
+	mockEcr.On("BatchGetImage", &ecr.BatchGetImageInput{
+		RegistryId:     aws.String("012345678910"),
+		RepositoryName: aws.String("removed"),
+		ImageIds:       []*ecr.ImageIdentifier{{ImageDigest: aws.String("sha256:abc")}},
+	}).Return(nil, awserr.New("RepositoryNotFoundException", "not found", nil))
+	a := &imageAudit{
+		serviceClient: mockServices,
+		ecrClient:     func(string, string) ecriface.ECRAPI { return mockEcr },
+	}
+
+	rep := a.Audit(context.Background())
+
+	mockEcr.AssertExpectations(t)
+	assert.Equal(t, 3, rep.Checked)
+	assert.Empty(t, rep.Errors)
+	assert.Equal(t, []missingImage{
+		{Image: "docker:" + ecrHost + "/app:2", Services: []string{"web", "worker"}, Code: "ImageNotFound", Reason: "Requested image not found"},
+		{Image: "docker:" + ecrHost + "/removed@sha256:abc", Services: []string{"gone"}, Code: "RepositoryNotFound"},
+	}, rep.Missing)
+	assert.Equal(t, 2.0, metrics.Value("ecr_updater_images_missing"))
+}
+
+func TestImageAudit_notifiesOnce(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	n := newNotifier([]string{server.URL}, webhookJSON)
+	image := ecrHost + "/app:2"
+	checked := map[string]bool{image: true}
+	missing := &auditReport{
+		Missing:    []missingImage{{Image: image, Services: []string{"web"}, Code: "ImageNotFound"}},
+		referenced: checked,
+		checked:    checked,
+	}
+
+	n.ImagesMissing(missing)
+	n.ImagesMissing(missing)
+	// found in between, so reported again
+	n.ImagesMissing(&auditReport{referenced: checked, checked: checked})
+	n.ImagesMissing(missing)
+
+	events := receiver.events(t)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, eventMissing, events[0].Event)
+		assert.Equal(t, []string{"web"}, events[0].Services)
+	}
+}
+
+func TestImageAudit_partialAuditKeepsDedupe(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	n := newNotifier([]string{server.URL}, webhookJSON)
+	image := ecrHost + "/app:2"
+	checked := map[string]bool{image: true}
+	missing := &auditReport{
+		Missing:    []missingImage{{Image: image, Services: []string{"web"}, Code: "ImageNotFound"}},
+		referenced: checked,
+		checked:    checked,
+	}
+
+	n.ImagesMissing(missing)
+	// the services could not be listed, then BatchGetImage failed
+	n.ImagesMissing(&auditReport{})
+	n.ImagesMissing(&auditReport{referenced: checked, checked: map[string]bool{}})
+	n.ImagesMissing(missing)
+
+	assert.Len(t, receiver.events(t), 1)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+)
+
+// inventoryGauges are the metrics set for each registry on every collection
+var inventoryGauges = []string{
+	"ecr_updater_inventory_repositories",
+	"ecr_updater_inventory_images",
+	"ecr_updater_inventory_untagged_images",
+	"ecr_updater_inventory_oldest_image_age_seconds",
+	"ecr_updater_inventory_newest_image_age_seconds",
+}
+
+func init() {
+	metrics.Describe("ecr_updater_inventory_repositories", "gauge", "Repositories in a managed registry.")
+	metrics.Describe("ecr_updater_inventory_images", "gauge", "Images, by digest, in a managed registry.")
+	metrics.Describe("ecr_updater_inventory_untagged_images", "gauge", "Untagged images in a managed registry.")
+	metrics.Describe("ecr_updater_inventory_oldest_image_age_seconds", "gauge", "Time since the oldest image of a managed registry was pushed.")
+	metrics.Describe("ecr_updater_inventory_newest_image_age_seconds", "gauge", "Time since the newest image of a managed registry was pushed.")
+}
+
+// repositoryInventory counts the images of one repository and records when
+// the oldest and newest were pushed
+type repositoryInventory struct {
+	Name         string     `json:"name"`
+	Images       int        `json:"images"`
+	Untagged     int        `json:"untagged"`
+	OldestPushed *time.Time `json:"oldestPushed,omitempty"`
+	NewestPushed *time.Time `json:"newestPushed,omitempty"`
+}
+
+// add records an image pushed at pushed
+func (repo *repositoryInventory) add(pushed *time.Time) {
+	if pushed == nil {
+		return
+	}
+	if repo.OldestPushed == nil || pushed.Before(*repo.OldestPushed) {
+		repo.OldestPushed = pushed
+	}
+	if repo.NewestPushed == nil || pushed.After(*repo.NewestPushed) {
+		repo.NewestPushed = pushed
+	}
+}
+
+// registryInventory counts the repositories and images of one registry
+type registryInventory struct {
+	RegistryID   string                `json:"registryId,omitempty"`
+	Region       string                `json:"region,omitempty"`
+	Repositories []repositoryInventory `json:"repositories"`
+	Images       int                   `json:"images"`
+	Untagged     int                   `json:"untagged"`
+	OldestPushed *time.Time            `json:"oldestPushed,omitempty"`
+	NewestPushed *time.Time            `json:"newestPushed,omitempty"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// inventoryReport is the outcome of one inventory collection
+type inventoryReport struct {
+	Started    time.Time           `json:"started"`
+	Finished   time.Time           `json:"finished"`
+	Registries []registryInventory `json:"registries"`
+}
+
+// inventoryCollector counts the repositories and images of the managed
+// registries for capacity and hygiene reviews
+type inventoryCollector struct {
+	Interval time.Duration
+
+	// registries returns the managed registry IDs by region
+	registries func() (map[string][]string, error)
+	ecrClient  func(account, region string) ecriface.ECRAPI
+	images     func(account, region string) imageDescriber
+}
+
+// inventories serves the most recent inventory on /inventory
+var inventories = &jsonHolder{}
+
+// Run collects the inventory every Interval while active returns true, until
+// ctx is done
+func (c *inventoryCollector) Run(ctx context.Context, active func() bool) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		if active() {
+			if rep, err := c.Collect(ctx); err != nil {
+				log.Printf("[inventory] %s\n", err)
+			} else {
+				inventories.Set(rep)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Collect pages through the repositories and images of every registry
+func (c *inventoryCollector) Collect(ctx context.Context) (*inventoryReport, error) {
+	registries, err := c.registries()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list registries: %s", err)
+	}
+	rep := &inventoryReport{Started: now(), Registries: []registryInventory{}}
+	var regions []string
+	for region := range registries {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		for _, registryID := range registries[region] {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			inv := collectRegistry(c.ecrClient(registryID, region), c.images(registryID, region), registryID)
+			inv.Region = region
+			rep.Registries = append(rep.Registries, inv)
+		}
+	}
+	// only the registries collected this time keep their series
+	for _, name := range inventoryGauges {
+		metrics.Reset(name)
+	}
+	for _, inv := range rep.Registries {
+		if inv.Error != "" {
+			continue
+		}
+		metrics.Set("ecr_updater_inventory_repositories", float64(len(inv.Repositories)), "registry", inv.RegistryID, "region", inv.Region)
+		metrics.Set("ecr_updater_inventory_images", float64(inv.Images), "registry", inv.RegistryID, "region", inv.Region)
+		metrics.Set("ecr_updater_inventory_untagged_images", float64(inv.Untagged), "registry", inv.RegistryID, "region", inv.Region)
+		if inv.OldestPushed != nil {
+			metrics.Set("ecr_updater_inventory_oldest_image_age_seconds", rep.Started.Sub(*inv.OldestPushed).Seconds(), "registry", inv.RegistryID, "region", inv.Region)
+			metrics.Set("ecr_updater_inventory_newest_image_age_seconds", rep.Started.Sub(*inv.NewestPushed).Seconds(), "registry", inv.RegistryID, "region", inv.Region)
+		}
+	}
+	rep.Finished = now()
+	log.Printf("[inventory] Collected %d registries in %s\n", len(rep.Registries), rep.Finished.Sub(rep.Started))
+	return rep, nil
+}
+
+// collectRegistry counts the images of every repository in a registry
+func collectRegistry(svc ecriface.ECRAPI, images imageDescriber, registryID string) registryInventory {
+	inv := registryInventory{RegistryID: registryID, Repositories: []repositoryInventory{}}
+	repositories, err := describeRepositories(svc, registryID)
+	if err != nil {
+		inv.Error = newAwsCallFailure("DescribeRepositories", registryID, err).Error
+		return inv
+	}
+	var names []string
+	for name := range repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		repo, err := describeImages(images, registryID, name)
+		if err != nil {
+			inv.Error = newAwsCallFailure("DescribeImages", registryID, err).Error
+			return inv
+		}
+		inv.Repositories = append(inv.Repositories, repo)
+		inv.Images += repo.Images
+		inv.Untagged += repo.Untagged
+		if repo.OldestPushed != nil && (inv.OldestPushed == nil || repo.OldestPushed.Before(*inv.OldestPushed)) {
+			inv.OldestPushed = repo.OldestPushed
+		}
+		if repo.NewestPushed != nil && (inv.NewestPushed == nil || repo.NewestPushed.After(*inv.NewestPushed)) {
+			inv.NewestPushed = repo.NewestPushed
+		}
+	}
+	return inv
+}
+
+// describeImages counts the images of a repository and finds the oldest and
+// newest push. DescribeImages returns one entry per digest, with its tags.
+func describeImages(svc imageDescriber, registryID, repository string) (repositoryInventory, error) {
+	repo := repositoryInventory{Name: repository}
+	input := &describeImagesInput{RepositoryName: aws.String(repository)}
+	if registryID != "" {
+		input.RegistryId = aws.String(registryID)
+	}
+	for page := 0; page < maxListPages; page++ {
+		resp, err := svc.DescribeImages(input)
+		if err != nil {
+			return repo, err
+		}
+		for _, image := range resp.ImageDetails {
+			repo.Images++
+			if len(image.ImageTags) == 0 {
+				repo.Untagged++
+			}
+			repo.add(image.ImagePushedAt)
+		}
+		if aws.StringValue(resp.NextToken) == "" {
+			return repo, nil
+		}
+		input = &describeImagesInput{RegistryId: input.RegistryId, RepositoryName: input.RepositoryName, NextToken: resp.NextToken}
+	}
+	return repo, fmt.Errorf("Image listing of %s exceeded %d pages", repository, maxListPages)
+}
+
+// imageDescriber calls ecr:DescribeImages
+type imageDescriber interface {
+	DescribeImages(input *describeImagesInput) (*describeImagesOutput, error)
+}
+
+// describeImagesInput is the input of ecr:DescribeImages, which the vendored
+// SDK predates
+type describeImagesInput struct {
+	_ struct{} `type:"structure"`
+
+	NextToken      *string `locationName:"nextToken" type:"string"`
+	RegistryId     *string `locationName:"registryId" type:"string"`
+	RepositoryName *string `locationName:"repositoryName" type:"string" required:"true"`
+}
+
+type describeImagesOutput struct {
+	_ struct{} `type:"structure"`
+
+	ImageDetails []*imageDetail `locationName:"imageDetails" type:"list"`
+	NextToken    *string        `locationName:"nextToken" type:"string"`
+}
+
+type imageDetail struct {
+	_ struct{} `type:"structure"`
+
+	ImageDigest   *string    `locationName:"imageDigest" type:"string"`
+	ImagePushedAt *time.Time `locationName:"imagePushedAt" type:"timestamp" timestampFormat:"unix"`
+	ImageTags     []*string  `locationName:"imageTags" type:"list"`
+}
+
+// ecrImages calls DescribeImages through an ECR client
+type ecrImages struct {
+	svc *ecr.ECR
+}
+
+func (e ecrImages) DescribeImages(input *describeImagesInput) (*describeImagesOutput, error) {
+	op := &request.Operation{
+		Name:       "DescribeImages",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	out := &describeImagesOutput{}
+	err := e.svc.NewRequest(op, input, out).Send()
+	return out, err
+}
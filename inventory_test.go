@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeImages serves DescribeImages pages by repository and token
+type fakeImages map[string]*describeImagesOutput
+
+func (f fakeImages) DescribeImages(input *describeImagesInput) (*describeImagesOutput, error) {
+	key := aws.StringValue(input.RegistryId) + "/" + aws.StringValue(input.RepositoryName) + "#" + aws.StringValue(input.NextToken)
+	if out, ok := f[key]; ok {
+		return out, nil
+	}
+	return nil, fmt.Errorf("unexpected DescribeImages %s", key)
+}
+
+func TestInventory_collect(t *testing.T) {
+	started := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return started }
+	defer func() { now = time.Now }()
+	pushed := func(d time.Duration) *time.Time {
+		t := started.Add(-d)
+		return &t
+	}
+	mockEcr := new(mocks.ECRAPI)
+	mockEcr.On("DescribeRepositories", &ecr.DescribeRepositoriesInput{RegistryId: aws.String("012345678910")}).Return(
+		&ecr.DescribeRepositoriesOutput{Repositories: []*ecr.Repository{
+			{RepositoryName: aws.String("app")},
+			{RepositoryName: aws.String("empty")},
+		}}, nil)
+	images := fakeImages{
+		"012345678910/app#": {
+			ImageDetails: []*imageDetail{
+				{ImageDigest: aws.String("sha256:a"), ImageTags: aws.StringSlice([]string{"1", "latest"}), ImagePushedAt: pushed(time.Hour)},
+			},
+			NextToken: aws.String("page-2"),
+		},
+		"012345678910/app#page-2": {
+			ImageDetails: []*imageDetail{{ImageDigest: aws.String("sha256:b"), ImagePushedAt: pushed(48 * time.Hour)}},
+		},
+		"012345678910/empty#": {},
+	}
+	c := &inventoryCollector{
+		registries: func() (map[string][]string, error) {
+			return map[string][]string{"us-east-1": {"012345678910"}}, nil
+		},
+		ecrClient: func(string, string) ecriface.ECRAPI { return mockEcr },
+		images:    func(string, string) imageDescriber { return images },
+	}
+
+	rep, err := c.Collect(context.Background())
+
+	assert.NoError(t, err)
+	mockEcr.AssertExpectations(t)
+	assert.Equal(t, []registryInventory{{
+		RegistryID: "012345678910",
+		Region:     "us-east-1",
+		Repositories: []repositoryInventory{
+			{Name: "app", Images: 2, Untagged: 1, OldestPushed: pushed(48 * time.Hour), NewestPushed: pushed(time.Hour)},
+			{Name: "empty"},
+		},
+		Images:       2,
+		Untagged:     1,
+		OldestPushed: pushed(48 * time.Hour),
+		NewestPushed: pushed(time.Hour),
+	}}, rep.Registries)
+	assert.Equal(t, 2.0, metrics.Value("ecr_updater_inventory_repositories", "registry", "012345678910", "region", "us-east-1"))
+	assert.Equal(t, 1.0, metrics.Value("ecr_updater_inventory_untagged_images", "registry", "012345678910", "region", "us-east-1"))
+	assert.Equal(t, (48 * time.Hour).Seconds(), metrics.Value("ecr_updater_inventory_oldest_image_age_seconds", "registry", "012345678910", "region", "us-east-1"))
+	assert.Equal(t, time.Hour.Seconds(), metrics.Value("ecr_updater_inventory_newest_image_age_seconds", "registry", "012345678910", "region", "us-east-1"))
+}
+
+func TestInventory_describeImages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "AmazonEC2ContainerRegistry_V20150921.DescribeImages", r.Header.Get("X-Amz-Target"))
+		b, _ := ioutil.ReadAll(r.Body)
+		assert.JSONEq(t, `{"registryId": "012345678910", "repositoryName": "app"}`, string(b))
+		w.Write([]byte(`{"imageDetails": [{"imageDigest": "sha256:a", "imageTags": ["latest"], "imagePushedAt": 1498910400}]}`))
+	}))
+	defer server.Close()
+	svc := ecr.New(session.New(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithEndpoint(server.URL).
+		WithCredentials(credentials.NewStaticCredentials("AKIAEXAMPLE", "secret", ""))))
+
+	repo, err := describeImages(ecrImages{svc}, "012345678910", "app")
+
+	assert.NoError(t, err)
+	pushed := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, repositoryInventory{Name: "app", Images: 1, OldestPushed: &pushed, NewestPushed: &pushed}, repo)
+}
+
+func TestInventory_dropsFailedRegistries(t *testing.T) {
+	mockEcr := new(mocks.ECRAPI)
+	mockEcr.On("DescribeRepositories", &ecr.DescribeRepositoriesInput{RegistryId: aws.String("109876543210")}).Return(
+		&ecr.DescribeRepositoriesOutput{}, nil).Once()
+	mockEcr.On("DescribeRepositories", &ecr.DescribeRepositoriesInput{RegistryId: aws.String("109876543210")}).Return(
+		nil, awserr.New("AccessDeniedException", "denied", nil)).Once()
+	c := &inventoryCollector{
+		registries: func() (map[string][]string, error) {
+			return map[string][]string{"eu-west-1": {"109876543210"}}, nil
+		},
+		ecrClient: func(string, string) ecriface.ECRAPI { return mockEcr },
+		images:    func(string, string) imageDescriber { return fakeImages{} },
+	}
+	series := func() bool {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		_, ok := metrics.values["ecr_updater_inventory_repositories"][formatLabels([]string{"registry", "109876543210", "region", "eu-west-1"})]
+		return ok
+	}
+
+	_, err := c.Collect(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, series())
+
+	rep, err := c.Collect(context.Background())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, rep.Registries[0].Error)
+	assert.False(t, series())
+}
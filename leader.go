@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_leader", "gauge", "Whether this replica holds the leader lease (1) or is on standby (0).")
+}
+
+// errNotLeader cancels work that only the leader may do after the lease was
+// lost partway through a cycle
+var errNotLeader = errors.New("Lost the leader lease")
+
+// lease records which replica may reconcile and until when
+type lease struct {
+	Holder  string    `json:"holder"`
+	Renewed time.Time `json:"renewed"`
+	Expires time.Time `json:"expires"`
+}
+
+// leaseLock stores the lease shared by every replica of the updater. A nil
+// lease from Get means none has been written yet.
+type leaseLock interface {
+	Get() (*lease, error)
+	Put(l lease) error
+}
+
+// leaderElector keeps this replica's claim on the lease up to date. Neither
+// lock offers compare-and-swap, so a claim is only trusted once it reads back.
+type leaderElector struct {
+	Lock     leaseLock
+	Identity string
+	TTL      time.Duration
+	// onElected is called when this replica becomes the leader
+	onElected func()
+
+	mu     sync.Mutex
+	leader bool
+}
+
+// IsLeader reports whether this replica currently holds the lease
+func (e *leaderElector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// TryAcquire claims the lease if it is free, expired or already ours
+func (e *leaderElector) TryAcquire() (bool, error) {
+	current, err := e.Lock.Get()
+	if err != nil {
+		return e.setLeader(false), err
+	}
+	t := now()
+	if current != nil && current.Holder != e.Identity && t.Before(current.Expires) {
+		return e.setLeader(false), nil
+	}
+	err = e.Lock.Put(lease{Holder: e.Identity, Renewed: t, Expires: t.Add(e.TTL)})
+	if err != nil {
+		return e.setLeader(false), err
+	}
+	current, err = e.Lock.Get()
+	if err != nil {
+		return e.setLeader(false), err
+	}
+	return e.setLeader(current != nil && current.Holder == e.Identity), nil
+}
+
+// Release gives the lease up so a standby can take over without waiting for
+// it to expire
+func (e *leaderElector) Release() error {
+	if !e.IsLeader() {
+		return nil
+	}
+	e.setLeader(false)
+	t := now()
+	return e.Lock.Put(lease{Holder: "", Renewed: t, Expires: t})
+}
+
+func (e *leaderElector) setLeader(leader bool) bool {
+	e.mu.Lock()
+	elected := leader && !e.leader
+	if leader != e.leader {
+		if leader {
+			log.Infof("[leader] %s acquired the leader lease", e.Identity)
+		} else {
+			log.Infof("[leader] %s is on standby", e.Identity)
+		}
+	}
+	e.leader = leader
+	e.mu.Unlock()
+
+	value := 0.0
+	if leader {
+		value = 1
+	}
+	metrics.Set("ecr_updater_leader", value)
+	if elected && e.onElected != nil {
+		e.onElected()
+	}
+	return leader
+}
+
+// Run renews or tries to acquire the lease every third of its TTL until ctx
+// is done, then releases it
+func (e *leaderElector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.TTL / 3)
+	defer ticker.Stop()
+	for {
+		if _, err := e.TryAcquire(); err != nil {
+			log.Printf("[leader] Error updating leader lease: %s\n", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err := e.Release(); err != nil {
+				log.Printf("[leader] Error releasing leader lease: %s\n", err)
+			}
+			return
+		}
+	}
+}
+
+// fileLeaseLock keeps the lease in a JSON file on storage shared by the
+// replicas
+type fileLeaseLock struct {
+	Path string
+}
+
+func (f *fileLeaseLock) Get() (*lease, error) {
+	b, err := ioutil.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &lease{}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("Unable to parse lease file %s: %s", f.Path, err)
+	}
+	return l, nil
+}
+
+// Put replaces the lease file atomically so readers never see a partial write
+func (f *fileLeaseLock) Put(l lease) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.Path, b)
+}
+
+// leaseMetadataKey is the key in a Rancher service's metadata holding the lease
+const leaseMetadataKey = "rancherEcrCredentialsLeader"
+
+// rancherLeaseLock keeps the lease in the metadata map of a Rancher service,
+// typically the updater's own service
+type rancherLeaseLock struct {
+	ServiceID     string
+	serviceClient client.ServiceOperations
+}
+
+func (rl *rancherLeaseLock) Get() (*lease, error) {
+	service, err := rl.serviceClient.ById(rl.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	if service == nil {
+		return nil, fmt.Errorf("Service %s not found", rl.ServiceID)
+	}
+	raw, ok := service.Metadata[leaseMetadataKey]
+	if !ok {
+		return nil, nil
+	}
+	// the metadata round trips through JSON, so decode it the same way
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	l := &lease{}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("Unable to parse lease on service %s: %s", rl.ServiceID, err)
+	}
+	return l, nil
+}
+
+func (rl *rancherLeaseLock) Put(l lease) error {
+	service, err := rl.serviceClient.ById(rl.ServiceID)
+	if err != nil {
+		return err
+	}
+	if service == nil {
+		return fmt.Errorf("Service %s not found", rl.ServiceID)
+	}
+	metadata := map[string]interface{}{}
+	for k, v := range service.Metadata {
+		metadata[k] = v
+	}
+	metadata[leaseMetadataKey] = l
+	_, err = rl.serviceClient.Update(service, &client.Service{Metadata: metadata})
+	return err
+}
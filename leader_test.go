@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLeader_fileLease(t *testing.T) {
+	current := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	dir, err := ioutil.TempDir("", "leader")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	lock := &fileLeaseLock{Path: filepath.Join(dir, "leader.json")}
+
+	elected := 0
+	first := &leaderElector{Lock: lock, Identity: "updater-1", TTL: time.Minute, onElected: func() { elected++ }}
+	second := &leaderElector{Lock: lock, Identity: "updater-2", TTL: time.Minute}
+
+	leader, err := first.TryAcquire()
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	leader, err = second.TryAcquire()
+	assert.NoError(t, err)
+	assert.False(t, leader)
+
+	// renewing keeps the lease, and only the first acquisition counts as an election
+	current = current.Add(30 * time.Second)
+	leader, _ = first.TryAcquire()
+	assert.True(t, leader)
+	assert.Equal(t, 1, elected)
+
+	// the standby takes over once the lease expires
+	current = current.Add(2 * time.Minute)
+	leader, _ = second.TryAcquire()
+	assert.True(t, leader)
+	leader, _ = first.TryAcquire()
+	assert.False(t, leader)
+
+	// a released lease is free straight away
+	assert.NoError(t, second.Release())
+	leader, _ = first.TryAcquire()
+	assert.True(t, leader)
+	assert.Equal(t, 2, elected)
+}
+
+func TestLeader_rancherLease(t *testing.T) {
+	current := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	service := &client.Service{
+		Resource: client.Resource{Id: "1s1"},
+		Metadata: map[string]interface{}{"other": "value"},
+	}
+	mockService := new(mocks.ServiceOperations)
+	mockService.On("ById", "1s1").Return(func(string) *client.Service { return service }, nil)
+	mockService.On("Update", mock.AnythingOfType("*client.Service"), mock.AnythingOfType("*client.Service")).Return(
+		func(existing *client.Service, updates interface{}) *client.Service {
+			// simulate the JSON round trip through the Rancher API
+			service = &client.Service{Resource: existing.Resource, Metadata: map[string]interface{}{}}
+			for k, v := range updates.(*client.Service).Metadata {
+				if l, ok := v.(lease); ok {
+					v = map[string]interface{}{
+						"holder":  l.Holder,
+						"renewed": l.Renewed.Format(time.RFC3339Nano),
+						"expires": l.Expires.Format(time.RFC3339Nano),
+					}
+				}
+				service.Metadata[k] = v
+			}
+			return service
+		}, nil)
+
+	e := &leaderElector{
+		Lock:     &rancherLeaseLock{ServiceID: "1s1", serviceClient: mockService},
+		Identity: "updater-1",
+		TTL:      time.Minute,
+	}
+	leader, err := e.TryAcquire()
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	assert.True(t, e.IsLeader())
+	assert.Equal(t, "value", service.Metadata["other"])
+
+	other := &leaderElector{Lock: e.Lock, Identity: "updater-2", TTL: time.Minute}
+	leader, err = other.TryAcquire()
+	assert.NoError(t, err)
+	assert.False(t, leader)
+}
@@ -1,19 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
 	"github.com/rancher/go-rancher/client"
@@ -27,7 +29,29 @@ type Rancher struct {
 	RegistryIds []string
 	AutoCreate  bool
 	ProxyHost   string
-	client      *client.RancherClient
+	// Name, Description and instance ID recorded on auto-created resources
+	AutoCreateName        string
+	AutoCreateDescription string
+	InstanceID            string
+	// Concurrency bounds how many authorization tokens are synced at once
+	Concurrency int
+	// TargetTimeout bounds how long syncing a single token may take
+	TargetTimeout time.Duration
+	// TransitionTimeout bounds how long to wait for a Rancher resource to
+	// settle into a new state, polling every TransitionPollInterval
+	TransitionTimeout      time.Duration
+	TransitionPollInterval time.Duration
+	// AWSBackoff retries throttled and failed GetAuthorizationToken calls
+	AWSBackoff *backoff
+	// IsLeader, if set, is checked again right before a registry is created
+	// so a replica that lost its lease mid-cycle doesn't create duplicates
+	IsLeader func() bool
+	// Discovery, if set, finds the registries to sync from the images in use
+	// instead of RegistryIds
+	Discovery *discovery
+	// state remembers synced tokens across restarts when STATE_FILE is set
+	state  *stateStore
+	client *client.RancherClient
 }
 
 func initLogger() {
@@ -47,11 +71,28 @@ func main() {
 	initLogger()
 	log.Info("Starting ECR Credential Updater")
 	r := Rancher{
-		URL:         os.Getenv("CATTLE_URL"),
-		AccessKey:   os.Getenv("CATTLE_ACCESS_KEY"),
-		SecretKey:   os.Getenv("CATTLE_SECRET_KEY"),
-		RegistryIds: []string{},
-		ProxyHost: 	 os.Getenv("ECR_PROXY_HOST"),
+		URL:           os.Getenv("CATTLE_URL"),
+		AccessKey:     os.Getenv("CATTLE_ACCESS_KEY"),
+		SecretKey:     os.Getenv("CATTLE_SECRET_KEY"),
+		RegistryIds:   []string{},
+		ProxyHost:     os.Getenv("ECR_PROXY_HOST"),
+		Concurrency:   4,
+		TargetTimeout: 2 * time.Minute,
+	}
+	r.TransitionTimeout = lookupDuration("TRANSITION_TIMEOUT", defaultTransitionTimeout)
+	r.TransitionPollInterval = lookupDuration("TRANSITION_POLL_INTERVAL", defaultTransitionPoll)
+	r.AWSBackoff = newBackoff(lookupInt("AWS_RETRY_ATTEMPTS", 4), lookupDuration("AWS_RETRY_DELAY", time.Second))
+	if val, ok := os.LookupEnv("AUTO_CREATE_NAME"); ok && val != "" {
+		r.AutoCreateName = val
+	}
+	if val, ok := os.LookupEnv("AUTO_CREATE_DESCRIPTION"); ok && val != "" {
+		r.AutoCreateDescription = val
+	}
+	r.Concurrency = lookupInt("CONCURRENCY", r.Concurrency)
+	r.TargetTimeout = lookupDuration("TARGET_TIMEOUT", r.TargetTimeout)
+	r.InstanceID = os.Getenv("UPDATER_INSTANCE_ID")
+	if r.InstanceID == "" {
+		r.InstanceID, _ = os.Hostname()
 	}
 	if val, ok := os.LookupEnv("AUTO_CREATE"); ok {
 		b, err := strconv.ParseBool(val)
@@ -60,13 +101,79 @@ func main() {
 		}
 		r.AutoCreate = b
 	}
-	rancher, err := client.NewRancherClient(&client.ClientOpts{
-		Url:       r.URL,
-		AccessKey: r.AccessKey,
-		SecretKey: r.SecretKey,
+	// validate the rest of the configuration before waiting for Rancher
+	var err error
+	if path := os.Getenv("STATE_FILE"); path != "" {
+		r.state, err = loadState(path)
+		if err != nil {
+			log.Fatalf("Unable to load state file: %s\n", err)
+		}
+		log.Printf("Using state file %s\n", path)
+	}
+
+	clients, err := awsClientConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure AWS clients: %s\n", err)
+	}
+	credentialsConfig := credentialsConfigFromEnv()
+	credentialsConfig.Clients = clients
+	creds, source, err := credentialsConfig.build()
+	if err != nil {
+		log.Fatalf("Unable to set up AWS credentials: %s\n", err)
+	}
+	awsSessions = newAWSSessionCache(creds, clients)
+	log.Printf("[awsClient] Using AWS credentials from %s\n", source)
+
+	providers := tokenProviders(&r)
+	var identity *callerIdentity
+	createRepositories := lookupBool("CREATE_REPOSITORIES", false)
+	if (usesECR(providers) && lookupBool("AWS_IDENTITY_CHECK", true)) || createRepositories {
+		// fail now rather than on the first cycle
+		identity, err = getCallerIdentity(awsSessions.STS())
+		if err != nil {
+			log.Fatalf("Unable to verify the AWS credentials from %s with sts:GetCallerIdentity: %s\n", source, err)
+		}
+		log.Printf("[awsClient] Authenticated as %s in account %s\n", aws.StringValue(identity.Arn), aws.StringValue(identity.Account))
+	}
+	var repositoryAccounts []string
+	if createRepositories {
+		accounts := os.Getenv("CREATE_REPOSITORIES_ACCOUNTS")
+		if accounts == "" {
+			log.Fatalf("CREATE_REPOSITORIES_ACCOUNTS is required when CREATE_REPOSITORIES is enabled\n")
+		}
+		repositoryAccounts = strings.Split(accounts, ",")
+		if err := checkRepositoryAccounts(repositoryAccounts, aws.StringValue(identity.Account)); err != nil {
+			log.Fatalf("Invalid CREATE_REPOSITORIES_ACCOUNTS: %s\n", err)
+		}
+	}
+
+	err = installRancherTransport(r.URL, rancherTLSConfig{
+		CABundle:           os.Getenv("RANCHER_CA_BUNDLE"),
+		ClientCert:         os.Getenv("RANCHER_CLIENT_CERT"),
+		ClientKey:          os.Getenv("RANCHER_CLIENT_KEY"),
+		InsecureSkipVerify: lookupBool("RANCHER_INSECURE_SKIP_VERIFY", false),
+		Timeout:            lookupDuration("RANCHER_TIMEOUT", 0),
 	})
 	if err != nil {
-		log.Fatalf("Unable to create Rancher API client: %s\n", err)
+		log.Fatalf("Unable to configure the Rancher connection: %s\n", err)
+	}
+	// signals are handled from here on, so none is missed between connecting
+	// and the daemon starting
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1)
+	// serve the health check while waiting for Rancher
+	server := healthcheck()
+	connectCtx, stopConnecting := untilInterrupted(signals)
+	rancher, err := connectRancher(connectCtx, newBackoff(0, lookupDuration("RANCHER_RETRY_DELAY", time.Second)), func() (*client.RancherClient, error) {
+		return client.NewRancherClient(&client.ClientOpts{
+			Url:       r.URL,
+			AccessKey: r.AccessKey,
+			SecretKey: r.SecretKey,
+		})
+	})
+	if interrupted := stopConnecting(); err != nil || interrupted {
+		log.Info("Stopped ECR Credential Updater before connecting to Rancher")
+		return
 	}
 	r.client = rancher
 	log.Debug("Created Rancher API Client")
@@ -76,184 +183,586 @@ func main() {
 		r.RegistryIds = strings.Split(ids, ",")
 	}
 
-	go healthcheck()
+	if lookupBool("DISCOVERY", false) {
+		r.Discovery = &discovery{
+			serviceClient:   r.client.Service,
+			containerClient: r.client.Container,
+			ecrClient:       awsRegistryClient,
+		}
+		if allow := os.Getenv("ECR_ACCOUNT_ALLOW"); allow != "" {
+			r.Discovery.Allow = strings.Split(allow, ",")
+		}
+		if deny := os.Getenv("ECR_ACCOUNT_DENY"); deny != "" {
+			r.Discovery.Deny = strings.Split(deny, ",")
+		}
+		log.Info("Discovering ECR registries from service and container images")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	retrier := newRancherRetrier(
+		ctx,
+		newBackoff(lookupInt("RANCHER_RETRY_ATTEMPTS", 4), lookupDuration("RANCHER_RETRY_DELAY", time.Second)),
+		newCircuitBreaker(lookupInt("RANCHER_CIRCUIT_THRESHOLD", 5), lookupDuration("RANCHER_CIRCUIT_COOLDOWN", time.Minute)),
+	)
+	registryClient := &retryingRegistryClient{ops: r.client.Registry, retrier: retrier}
+	registryCredentialClient := &retryingRegistryCredentialClient{ops: r.client.RegistryCredential, retrier: retrier}
+
+	var elector *leaderElector
+	electorDone := make(chan struct{})
+	refresh := make(chan struct{}, 1)
+	if mode := os.Getenv("LEADER_ELECTION"); mode != "" {
+		elector = &leaderElector{
+			Identity: r.InstanceID,
+			TTL:      lookupDuration("LEADER_ELECTION_TTL", time.Minute),
+			// a new leader refreshes right away in case the previous one
+			// stopped partway through its schedule
+			onElected: func() {
+				select {
+				case refresh <- struct{}{}:
+				default:
+				}
+			},
+		}
+		switch mode {
+		case "file":
+			path := os.Getenv("LEADER_ELECTION_FILE")
+			if path == "" {
+				path = "/var/lib/rancher-ecr-credentials/leader.json"
+			}
+			elector.Lock = &fileLeaseLock{Path: path}
+		case "rancher":
+			serviceID := os.Getenv("LEADER_ELECTION_SERVICE_ID")
+			if serviceID == "" {
+				log.Fatalf("LEADER_ELECTION_SERVICE_ID is required when LEADER_ELECTION is rancher\n")
+			}
+			elector.Lock = &rancherLeaseLock{ServiceID: serviceID, serviceClient: r.client.Service}
+		default:
+			log.Fatalf("Unknown LEADER_ELECTION mode: %s\n", mode)
+		}
+		if elector.TTL <= 0 {
+			log.Fatalf("LEADER_ELECTION_TTL must be positive\n")
+		}
+		log.Printf("[leader] Using %s leader election as %s\n", mode, elector.Identity)
+		r.IsLeader = elector.IsLeader
+		go func() {
+			defer close(electorDone)
+			elector.Run(ctx)
+		}()
+	} else {
+		close(electorDone)
+	}
+
+	var publisher *statusPublisher
+	if mode := os.Getenv("RANCHER_STATUS"); mode != "" {
+		publisher = &statusPublisher{
+			InstanceID:     r.InstanceID,
+			registryClient: registryClient,
+			eventClient:    r.client.ExternalEvent,
+			retrier:        retrier,
+		}
+		for _, m := range strings.Split(mode, ",") {
+			switch m {
+			case "data":
+				publisher.Data = true
+			case "description":
+				publisher.Description = true
+			case "events":
+				publisher.Events = true
+			default:
+				log.Fatalf("Unknown RANCHER_STATUS mode: %s\n", m)
+			}
+		}
+	}
+
+	var remediate *remediator
+	if lookupBool("REMEDIATE", false) {
+		remediate = &remediator{
+			DryRun:          lookupBool("REMEDIATE_DRY_RUN", false),
+			MaxActions:      lookupInt("REMEDIATE_MAX_ACTIONS", 10),
+			serviceClient:   r.client.Service,
+			containerClient: r.client.Container,
+			retrier:         retrier,
+		}
+	}
 
-	r.updateEcr(awsClient(), r.client.Registry, r.client.RegistryCredential)
-	ticker := time.NewTicker(6 * time.Hour)
-	for {
-		log.Debug("Sleeping until next poll cycle")
-		<-ticker.C
-		r.updateEcr(awsClient(), r.client.Registry, r.client.RegistryCredential)
+	var pinner *credentialPinner
+	if lookupBool("PIN_CREDENTIALS", false) {
+		pinner = &credentialPinner{
+			Upgrade:       lookupBool("PIN_CREDENTIALS_UPGRADE", false),
+			MaxUpgrades:   lookupInt("PIN_CREDENTIALS_MAX_UPGRADES", 5),
+			rancher:       &r,
+			serviceClient: r.client.Service,
+			retrier:       retrier,
+		}
 	}
+
+	var repositories *repositoryCreator
+	if createRepositories {
+		repositories = &repositoryCreator{
+			Accounts:      repositoryAccounts,
+			Policy:        defaultRepositoryPolicy,
+			DryRun:        lookupBool("CREATE_REPOSITORIES_DRY_RUN", false),
+			serviceClient: r.client.Service,
+			ecrClient:     awsRegistryClient,
+		}
+		if path := os.Getenv("REPOSITORY_POLICY_FILE"); path != "" {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Fatalf("Unable to read REPOSITORY_POLICY_FILE: %s\n", err)
+			}
+			repositories.Policy = string(b)
+		}
+	}
+
+	var pullPolicies *pullPolicyManager
+	if path := os.Getenv("PULL_POLICY_FILE"); path != "" {
+		rules, err := loadPullRules(path)
+		if err != nil {
+			log.Fatalf("Unable to load PULL_POLICY_FILE: %s\n", err)
+		}
+		pullPolicies = &pullPolicyManager{
+			Rules:     rules,
+			DryRun:    lookupBool("PULL_POLICY_DRY_RUN", false),
+			ecrClient: awsRegistryClient,
+		}
+	}
+
+	var notify *notifier
+	if urls := os.Getenv("WEBHOOK_URLS"); urls != "" {
+		notify = newNotifier(strings.Split(urls, ","), webhookJSON)
+		if format := os.Getenv("WEBHOOK_FORMAT"); format != "" {
+			if format != webhookJSON && format != webhookSlack {
+				log.Fatalf("Unknown WEBHOOK_FORMAT: %s\n", format)
+			}
+			notify.Format = format
+		}
+		if text := os.Getenv("WEBHOOK_TEMPLATE"); text != "" {
+			notify.Template, err = parseWebhookTemplate(text)
+			if err != nil {
+				log.Fatalf("Unable to parse WEBHOOK_TEMPLATE: %s\n", err)
+			}
+		}
+		notify.FailureThreshold = lookupInt("WEBHOOK_FAILURE_THRESHOLD", notify.FailureThreshold)
+		notify.ExpiryWarning = lookupDuration("WEBHOOK_EXPIRY_WARNING", notify.ExpiryWarning)
+		notify.CheckInterval = lookupDuration("WEBHOOK_EXPIRY_CHECK_INTERVAL", notify.CheckInterval)
+		notify.RateLimit = lookupInt("WEBHOOK_RATE_LIMIT", notify.RateLimit)
+		notify.Instance = r.InstanceID
+		notify.Seed(r.state)
+		if notify.CheckInterval <= 0 {
+			log.Fatalf("WEBHOOK_EXPIRY_CHECK_INTERVAL must be positive\n")
+		}
+		go notify.Run(ctx, elector.IsLeader)
+	}
+
+	if lookupBool("IMAGE_AUDIT", false) {
+		audit := &imageAudit{
+			Interval:      lookupDuration("IMAGE_AUDIT_INTERVAL", 15*time.Minute),
+			serviceClient: r.client.Service,
+			ecrClient:     awsRegistryClient,
+			notify:        notify,
+		}
+		if audit.Interval <= 0 {
+			log.Fatalf("IMAGE_AUDIT_INTERVAL must be positive\n")
+		}
+		go audit.Run(ctx, elector.IsLeader)
+	}
+
+	if lookupBool("INVENTORY", false) {
+		collector := &inventoryCollector{
+			Interval: lookupDuration("INVENTORY_INTERVAL", time.Hour),
+			registries: func() (map[string][]string, error) {
+				if r.Discovery != nil {
+					return r.Discovery.Discover()
+				}
+				if len(r.RegistryIds) == 0 {
+					// the registry of the AWS credentials
+					return map[string][]string{"": {""}}, nil
+				}
+				return map[string][]string{"": r.RegistryIds}, nil
+			},
+			ecrClient: awsRegistryClient,
+			images: func(account, region string) imageDescriber {
+				return ecrImages{awsSessions.ECR(account, region)}
+			},
+		}
+		if collector.Interval <= 0 {
+			log.Fatalf("INVENTORY_INTERVAL must be positive\n")
+		}
+		go collector.Run(ctx, elector.IsLeader)
+	}
+
+	// pick up the schedule where the previous run left it
+	interval := 6 * time.Hour
+	d := &daemon{
+		Interval:     interval,
+		InitialDelay: r.state.NextDue(interval).Sub(now()),
+		DrainTimeout: lookupDuration("DRAIN_TIMEOUT", 30*time.Second),
+		refresh:      refresh,
+		update: func(ctx context.Context) {
+			if !elector.IsLeader() {
+				log.Info("[leader] Not the leader, skipping update cycle")
+				return
+			}
+			rep := r.update(ctx, providers, registryClient, registryCredentialClient)
+			publisher.Publish(rep)
+			remediate.Remediate(rep)
+			pinner.Pin(ctx, rep)
+			repositories.Reconcile(ctx)
+			pullPolicies.Converge(ctx)
+			notify.Observe(rep)
+		},
+		shutdown: func(ctx context.Context) {
+			// stop retrying Rancher calls still running in the background and
+			// release the leader lease
+			cancel()
+			select {
+			case <-electorDone:
+			case <-ctx.Done():
+			}
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Error shutting down health check listener: %s\n", err)
+			}
+		},
+	}
+	d.Run(ctx, signals)
+	log.Info("Stopped ECR Credential Updater")
+}
+
+// usesECR reports whether one of the providers requests ECR tokens
+func usesECR(providers []TokenProvider) bool {
+	for _, p := range providers {
+		if _, ok := p.(*ecrProvider); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenProviders builds the providers listed in TOKEN_PROVIDERS
+func tokenProviders(r *Rancher) []TokenProvider {
+	names := "ecr"
+	if val, ok := os.LookupEnv("TOKEN_PROVIDERS"); ok && val != "" {
+		names = val
+	}
+	var providers []TokenProvider
+	for _, name := range strings.Split(names, ",") {
+		switch name {
+		case "ecr":
+			providers = append(providers, &ecrProvider{rancher: r, svc: awsClient(), ecrClient: awsRegistryClient})
+		case "gcp":
+			registries := os.Getenv("GCP_REGISTRIES")
+			if registries == "" {
+				log.Fatalf("GCP_REGISTRIES is required for the gcp token provider\n")
+			}
+			p, err := newGcpProvider(os.Getenv("GCP_SERVICE_ACCOUNT_KEY"), strings.Split(registries, ","))
+			if err != nil {
+				log.Fatalf("Unable to load GCP_SERVICE_ACCOUNT_KEY: %s\n", err)
+			}
+			providers = append(providers, p)
+		case "azure":
+			registries := os.Getenv("ACR_REGISTRIES")
+			if registries == "" {
+				log.Fatalf("ACR_REGISTRIES is required for the azure token provider\n")
+			}
+			p := newAcrProvider(strings.Split(registries, ","), os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET"))
+			if authority := os.Getenv("AZURE_AUTHORITY_HOST"); authority != "" {
+				p.Authority = strings.TrimSuffix(authority, "/")
+			}
+			providers = append(providers, p)
+		default:
+			log.Fatalf("Unknown token provider in TOKEN_PROVIDERS: %s\n", name)
+		}
+	}
+	return providers
+}
+
+// lookupInt reads a positive integer config param, exiting if it is invalid
+func lookupInt(name string, def int) int {
+	val, ok := os.LookupEnv(name)
+	if !ok || val == "" {
+		return def
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil || i < 1 {
+		log.Fatalf("Unable to parse positive integer value from %s: %s\n", name, val)
+	}
+	return i
+}
+
+// lookupBool reads a boolean config param, exiting if it is invalid
+func lookupBool(name string, def bool) bool {
+	val, ok := os.LookupEnv(name)
+	if !ok || val == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		log.Fatalf("Unable to parse boolean value from %s: %s\n", name, err)
+	}
+	return b
+}
+
+// lookupDuration reads a duration config param such as "90s", exiting if it
+// is invalid
+func lookupDuration(name string, def time.Duration) time.Duration {
+	val, ok := os.LookupEnv(name)
+	if !ok || val == "" {
+		return def
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatalf("Unable to parse duration value from %s: %s\n", name, err)
+	}
+	return d
 }
 
 func (r *Rancher) updateEcr(
+	ctx context.Context,
 	svc ecriface.ECRAPI,
 	registryClient client.RegistryOperations,
-	registryCredentialClient client.RegistryCredentialOperations) {
+	registryCredentialClient client.RegistryCredentialOperations) *report {
+
+	return r.update(ctx, []TokenProvider{&ecrProvider{rancher: r, svc: svc}}, registryClient, registryCredentialClient)
+}
+
+// update runs one cycle: it collects tokens from every provider and syncs
+// them into Rancher
+func (r *Rancher) update(
+	ctx context.Context,
+	providers []TokenProvider,
+	registryClient client.RegistryOperations,
+	registryCredentialClient client.RegistryCredentialOperations) *report {
 
 	log.Println("Updating ECR Credentials")
+	rep := &report{Started: now()}
 
-	request := &ecr.GetAuthorizationTokenInput{}
-	if len(r.RegistryIds) > 0 {
-		request = &ecr.GetAuthorizationTokenInput{RegistryIds: aws.StringSlice(r.RegistryIds)}
-	}
-	resp, err := svc.GetAuthorizationToken(request)
-	log.Debug(resp)
-	if err != nil {
-		log.Printf("Error calling AWS API: %s\n", err)
-		return
+	var authData []registryAuth
+	for _, provider := range providers {
+		tokens, failures := provider.Tokens(ctx)
+		for i := range tokens {
+			tokens[i].Provider = provider.Name()
+		}
+		authData = append(authData, tokens...)
+		rep.ProviderFailures = append(rep.ProviderFailures, failures...)
 	}
-	log.Println("Returned from AWS GetAuthorizationToken call successfully")
-
-	if len(resp.AuthorizationData) < 1 {
+	if len(authData) < 1 {
+		if len(rep.ProviderFailures) > 0 {
+			return rep.finish(fmt.Errorf("Unable to retrieve any registry authorization tokens"))
+		}
 		log.Println("Request did not return authorization data")
-		return
+		return rep.finish(nil)
 	}
+	log.Printf("Retrieved %d authorization tokens\n", len(authData))
 
-	for _, data := range resp.AuthorizationData {
-		r.processToken(data, registryClient, registryCredentialClient)
+	cache := newRegistryCache(registryClient, registryCredentialClient)
+	rep.Results = r.reconcile(ctx, authData, func(ctx context.Context, data registryAuth) targetResult {
+		return r.processToken(ctx, data, cache, registryClient, registryCredentialClient)
+	})
+	if err := r.state.Save(); err != nil {
+		log.Printf("Error saving state file: %s\n", err)
 	}
+	return rep.finish(nil)
 }
 
 func (r *Rancher) processToken(
-	data *ecr.AuthorizationData,
+	ctx context.Context,
+	data registryAuth,
+	cache *registryCache,
 	registryClient client.RegistryOperations,
-	registryCredentialClient client.RegistryCredentialOperations) {
+	registryCredentialClient client.RegistryCredentialOperations) targetResult {
+
+	result := targetResult{Endpoint: data.Endpoint}
 
-	bytes, err := base64.StdEncoding.DecodeString(*data.AuthorizationToken)
+	bytes, err := base64.StdEncoding.DecodeString(data.Auth)
 	if err != nil {
-		log.Printf("[%s] Error decoding authorization token: %s\n", *data.ProxyEndpoint, err)
-		return
+		log.Printf("[%s] Error decoding authorization token: %s\n", data.Endpoint, err)
+		return result.failed(err)
 	}
 	token := string(bytes[:len(bytes)])
 
 	authTokens := strings.Split(token, ":")
 	if len(authTokens) != 2 {
-		log.Printf("[%s] Authorization token does not contain data in <user>:<password> format: %s\n", *data.ProxyEndpoint, token)
-		return
+		log.Printf("[%s] Authorization token does not contain data in <user>:<password> format: %s\n", data.Endpoint, token)
+		return result.failed(fmt.Errorf("Authorization token is not in <user>:<password> format"))
 	}
 
-	registryURL, err := url.Parse(*data.ProxyEndpoint)
+	registryURL, err := url.Parse(data.Endpoint)
 	if err != nil {
-		log.Printf("[%s] Error parsing registry URL: %s\n", *data.ProxyEndpoint, err)
-		return
+		log.Printf("[%s] Error parsing registry URL: %s\n", data.Endpoint, err)
+		return result.failed(err)
 	}
 
 	ecrUsername := authTokens[0]
 	ecrPassword := authTokens[1]
 	ecrHost := ""
-	if len(r.ProxyHost) > 0 {
+	// the proxy only fronts ECR, not the registries of other token providers
+	if len(r.ProxyHost) > 0 && parseEcrHost(registryURL.Host).Account != "" {
 		ecrHost = r.ProxyHost
 	} else {
 		ecrHost = registryURL.Host
 	}
+	result.Host = ecrHost
 
-	registries, err := registryClient.List(&client.ListOpts{})
+	// targets sharing a host (e.g. behind ECR_PROXY_HOST) must not race to
+	// update or create the same registry
+	unlock := cache.LockHost(ecrHost)
+	defer unlock()
+
+	registries, err := cache.Registries()
 	if err != nil {
-		log.Printf("[%s] Failed to retrieve registries: %s\n", *data.ProxyEndpoint, err)
-		return
+		log.Printf("[%s] Failed to retrieve registries: %s\n", data.Endpoint, err)
+		return result.failed(err)
 	}
-	log.Printf("[%s] Looking for configured registry for host: %s\n", *data.ProxyEndpoint, ecrHost)
-	for _, registry := range registries.Data {
+	log.Printf("[%s] Looking for configured registry for host: %s\n", data.Endpoint, ecrHost)
+	var found *client.Registry
+	for i, registry := range registries {
 		serverAddress, err := url.Parse(registry.ServerAddress)
 		if err != nil {
-			log.Printf("[%s] Failed to parse configured registry URL: %s\n", *data.ProxyEndpoint, registry.ServerAddress)
-			break
+			log.Printf("[%s] Failed to parse configured registry URL: %s\n", data.Endpoint, registry.ServerAddress)
+			continue
 		}
 		registryHost := serverAddress.Host
 		if registryHost == "" {
 			registryHost = serverAddress.Path
 		}
 		if registryHost == ecrHost {
-			credentials, err := registryCredentialClient.List(&client.ListOpts{
-				Filters: map[string]interface{}{
-					"registryId": registry.Id,
-				},
-			})
-			if err != nil {
-				log.Printf("[%s] Failed to retrieved registry credentials for id: %s, %s\n", *data.ProxyEndpoint, registry.Id, err)
-				break
-			}
-			if len(credentials.Data) != 1 {
-				log.Printf("[%s] No credentials retrieved for registry: %s\n", *data.ProxyEndpoint, registry.Id)
-				break
-			}
-			credential := credentials.Data[0]
-			_, err = registryCredentialClient.Update(&credential, &client.RegistryCredential{
-				PublicValue: ecrUsername,
-				SecretValue: ecrPassword,
-				Email:       "not-really@required.anymore",
-			})
-			if err != nil {
-				log.Printf("[%s] Failed to update registry credential %s, %s\n", *data.ProxyEndpoint, credential.Id, err)
-			} else {
-				log.Printf("[%s] Successfully updated credentials %s for registry %s; registry address: %s\n", *data.ProxyEndpoint, credential.Id, registry.Id, registryHost)
-			}
-			return
+			found = &registries[i]
+			break
 		}
 	}
-	log.Printf("[%s] Did not find an existing reigstry for host: %s\n", *data.ProxyEndpoint, ecrHost)
-
-	// If we made it this far, it means we were not able to find an existing registry to update in Rancher
-	if r.AutoCreate {
-		log.Printf("[%s] Automatically creating registry for host: %s\n", *data.ProxyEndpoint, ecrHost)
-		registry, err := registryClient.Create(&client.Registry{
-			ServerAddress: ecrHost,
-		})
-		if err != nil {
-			log.Printf("[%s] Error creating registry for host: %s, %s\n", *data.ProxyEndpoint, ecrHost, err)
-			return
-		}
-		_, err = registryCredentialClient.Create(&client.RegistryCredential{
-			RegistryId:  registry.Id,
-			PublicValue: ecrUsername,
-			SecretValue: ecrPassword,
-			Email:       "not-really@required.anymore",
-		})
-		log.Printf("[%s] Successfully created regristy %s and updated credential\n", *data.ProxyEndpoint, registry.Id)
 
-		if err != nil {
-			log.Printf("[%s] Error creating registry credential for host: %s, %s\n", *data.ProxyEndpoint, ecrHost, err)
-			return
+	target := registryToken{
+		Endpoint: data.Endpoint,
+		Provider: data.Provider,
+		Host:     ecrHost,
+		Info:     parseEcrHost(registryURL.Host),
+		Username: ecrUsername,
+		Password: ecrPassword,
+	}
+	if found == nil {
+		log.Printf("[%s] Did not find an existing reigstry for host: %s\n", data.Endpoint, ecrHost)
+		if !r.AutoCreate {
+			log.Printf("[%s] Failed to find Rancher registry to update for ECR Host: %s\n", data.Endpoint, ecrHost)
+			result.Status = statusMissing
+			return result
 		}
-	} else {
-		log.Printf("[%s] Failed to find Rancher registry to update for ECR Host: %s\n", *data.ProxyEndpoint, ecrHost)
+		return r.remember(data, target, r.createRegistry(ctx, target, result, cache, registryClient, registryCredentialClient))
+	}
+
+	registry := *found
+	result.RegistryID = registry.Id
+	credentials, err := cache.Credentials(registry.Id)
+	if err != nil {
+		log.Printf("[%s] Failed to retrieved registry credentials for id: %s, %s\n", data.Endpoint, registry.Id, err)
+		return result.failed(err)
+	}
+	if len(credentials) == 0 && r.AutoCreate {
+		// left behind by an earlier run that failed partway through creating it
+		log.Printf("[%s] Adopting registry %s without credentials\n", data.Endpoint, registry.Id)
+		return r.remember(data, target, r.addCredential(ctx, target, registry, result, cache, registryClient, registryCredentialClient))
+	}
+	if len(credentials) != 1 {
+		log.Printf("[%s] No credentials retrieved for registry: %s\n", data.Endpoint, registry.Id)
+		return result.failed(fmt.Errorf("Expected one credential for registry %s, found %d", registry.Id, len(credentials)))
+	}
+	credential := credentials[0]
+	result.CredentialID = credential.Id
+	if last, ok := r.state.Get(target.Endpoint); ok && last.CredentialID == credential.Id &&
+		last.Fingerprint == fingerprint(ecrUsername, ecrPassword) && credential.State == stateActive {
+		log.Printf("[%s] Token unchanged since %s, not updating credential %s\n", data.Endpoint, last.LastSuccess.Format(time.RFC3339), credential.Id)
+		result.State = credential.State
+		result.Status = statusUnchanged
+		return r.remember(data, target, result)
 	}
-	return
+	updates := &client.RegistryCredential{
+		PublicValue: ecrUsername,
+		SecretValue: ecrPassword,
+		Email:       "not-really@required.anymore",
+	}
+	if isManaged(credential.Data) {
+		updates.Data = r.rotatedData(credential.Data)
+	}
+	if ctx.Err() != nil {
+		return result.cancelled(ctx.Err())
+	}
+	updated, err := registryCredentialClient.Update(&credential, updates)
+	if err != nil {
+		log.Printf("[%s] Failed to update registry credential %s, %s\n", data.Endpoint, credential.Id, err)
+		return result.failed(err)
+	}
+	settled, err := r.settleCredential(ctx, data.Endpoint, updated, registryCredentialClient)
+	cache.SetCredentials(registry.Id, []client.RegistryCredential{*settled})
+	result.State = settled.State
+	if err != nil {
+		log.Printf("[%s] Registry credential %s did not become active: %s\n", data.Endpoint, credential.Id, err)
+		return result.failed(err)
+	}
+	log.Printf("[%s] Successfully updated credentials %s for registry %s; registry address: %s\n", data.Endpoint, credential.Id, registry.Id, ecrHost)
+	result.Status = statusUpdated
+	return r.remember(data, target, result)
+}
+
+// remember records a successfully synced token and its expiry
+func (r *Rancher) remember(data registryAuth, target registryToken, result targetResult) targetResult {
+	switch result.Status {
+	case statusUpdated, statusCreated, statusUnchanged:
+	default:
+		return result
+	}
+	t := targetState{
+		Fingerprint:  fingerprint(target.Username, target.Password),
+		CredentialID: result.CredentialID,
+		LastSuccess:  now(),
+	}
+	if data.ExpiresAt != nil {
+		t.ExpiresAt = *data.ExpiresAt
+		result.ExpiresAt = data.ExpiresAt
+	}
+	r.state.Record(target.Endpoint, t)
+	return result
 }
 
-func healthcheck() {
+func healthcheck() *http.Server {
 
 	listenPort := "8080"
 	p, ok := os.LookupEnv("LISTEN_PORT")
 	if ok {
 		listenPort = p
 	}
-	http.HandleFunc("/ping", ping)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", ping)
+	mux.Handle("/metrics", metrics)
+	mux.Handle("/status", status)
+	mux.Handle("/images", audits)
+	mux.Handle("/inventory", inventories)
+	server := &http.Server{Addr: fmt.Sprintf(":%s", listenPort), Handler: mux}
 	log.Printf("Starting Healthcheck listener at :%s/ping\n", listenPort)
-	err := http.ListenAndServe(fmt.Sprintf(":%s", listenPort), nil)
-	if err != nil {
-		log.Fatal("Error creating health check listener: ", err)
-	}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal("Error creating health check listener: ", err)
+		}
+	}()
+	return server
 }
 
 func ping(w http.ResponseWriter, r *http.Request) {
 	log.Debug("Recieved Health Check Request")
+	// the process is healthy while it waits, so this doesn't fail
+	if waiting, _ := rancherConnection.Waiting(); waiting {
+		fmt.Fprintf(w, "waiting for Rancher")
+		return
+	}
 	fmt.Fprintf(w, "pong!")
 }
 
 func awsClient() *ecr.ECR {
-	roleArn, ok := os.LookupEnv("AWS_ROLE_ARN")
-	if ok {
-		log.Printf("[awsClient] Assuming Role: %s\n", roleArn)
-		return ecr.New(
-			session.New(
-				aws.NewConfig().WithCredentials(
-					stscreds.NewCredentials(session.New(), roleArn),
-				),
-			),
-		)
-	}
-	return ecr.New(session.New())
+	return awsSessions.ECR("", "")
+}
+
+// awsRegistryClient returns the cached ECR client for the registries of an
+// account in a region, or in the default region when it is empty
+func awsRegistryClient(account, region string) ecriface.ECRAPI {
+	return awsSessions.ECR(account, region)
 }
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ecr"
@@ -56,7 +58,7 @@ func TestMain_basic(t *testing.T) {
 		Email:       "not-really@required.anymore",
 	}).Return(&client.RegistryCredential{}, nil)
 
-	r.updateEcr(mockEcr, mockRegistry, mockRegistryCredential)
+	r.updateEcr(context.Background(), mockEcr, mockRegistry, mockRegistryCredential)
 
 	mockEcr.AssertExpectations(t)
 	mockRegistry.AssertExpectations(t)
@@ -64,8 +66,34 @@ func TestMain_basic(t *testing.T) {
 }
 
 func TestMain_autoCreate(t *testing.T) {
+	now = func() time.Time { return time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
 	r := &Rancher{
-		AutoCreate: true,
+		AutoCreate:            true,
+		AutoCreateName:        defaultAutoCreateName,
+		AutoCreateDescription: defaultAutoCreateDescription,
+		InstanceID:            "updater-1",
+	}
+	ownership := map[string]interface{}{
+		metadataKey: map[string]interface{}{
+			"managedBy":    managedBy,
+			"provider":     "ecr",
+			"awsAccountId": "012345678910",
+			"awsRegion":    "us-east-1",
+			"instanceId":   "updater-1",
+			"created":      "2017-07-01T12:00:00Z",
+		},
+	}
+	credentialOwnership := map[string]interface{}{
+		metadataKey: map[string]interface{}{
+			"managedBy":    managedBy,
+			"provider":     "ecr",
+			"awsAccountId": "012345678910",
+			"awsRegion":    "us-east-1",
+			"instanceId":   "updater-1",
+			"created":      "2017-07-01T12:00:00Z",
+			"lastRotated":  "2017-07-01T12:00:00Z",
+		},
 	}
 	mockEcr := new(mocks.ECRAPI)
 	mockRegistry := new(mocks.RegistryOperations)
@@ -90,6 +118,9 @@ func TestMain_autoCreate(t *testing.T) {
 	mockRegistry.On("Create",
 		&client.Registry{
 			ServerAddress: "012345678910.dkr.ecr.us-east-1.amazonaws.com",
+			Name:          "ecr-012345678910-us-east-1",
+			Description:   "Amazon ECR registry for account 012345678910 in us-east-1, managed by rancher-ecr-credentials",
+			Data:          ownership,
 		},
 	).Return(&client.Registry{
 		Resource: client.Resource{
@@ -103,6 +134,9 @@ func TestMain_autoCreate(t *testing.T) {
 		PublicValue: "mockUser",
 		SecretValue: "mockPassword",
 		Email:       "not-really@required.anymore",
+		Name:        "ecr-012345678910-us-east-1",
+		Description: "Amazon ECR registry for account 012345678910 in us-east-1, managed by rancher-ecr-credentials",
+		Data:        credentialOwnership,
 	}).Return(&client.RegistryCredential{
 		Resource:    client.Resource{Id: "1rc1"},
 		RegistryId:  "1r1",
@@ -111,7 +145,7 @@ func TestMain_autoCreate(t *testing.T) {
 		Email:       "not-really@required.anymore",
 	}, nil)
 
-	r.updateEcr(mockEcr, mockRegistry, mockRegistryCredential)
+	r.updateEcr(context.Background(), mockEcr, mockRegistry, mockRegistryCredential)
 
 	mockEcr.AssertExpectations(t)
 	mockRegistry.AssertExpectations(t)
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// metadataKey is the key in a Rancher resource's Data map under which the
+	// updater records ownership metadata
+	metadataKey = "rancherEcrCredentials"
+	// managedBy identifies resources created by this updater
+	managedBy = "rancher-ecr-credentials"
+
+	defaultAutoCreateName        = "ecr-{account}-{region}"
+	defaultAutoCreateDescription = "Amazon ECR registry for account {account} in {region}, managed by rancher-ecr-credentials"
+)
+
+// autoCreateDefaults are the name and description given to auto-created
+// resources by token provider, unless AUTO_CREATE_NAME or
+// AUTO_CREATE_DESCRIPTION are set
+var autoCreateDefaults = map[string]struct{ Name, Description string }{
+	"ecr":   {defaultAutoCreateName, defaultAutoCreateDescription},
+	"gcp":   {"gcp-{host}", "Google registry {host}, managed by rancher-ecr-credentials"},
+	"azure": {"acr-{host}", "Azure Container Registry {host}, managed by rancher-ecr-credentials"},
+}
+
+// now is swapped out in tests to get deterministic timestamps
+var now = time.Now
+
+// ecrHostInfo holds the AWS account and region parsed from an ECR registry host
+type ecrHostInfo struct {
+	Account string
+	Region  string
+}
+
+// parseEcrHost extracts the account and region from a host in the form
+// <account>.dkr.ecr.<region>.amazonaws.com. Hosts that do not match the
+// pattern return an empty ecrHostInfo.
+func parseEcrHost(host string) ecrHostInfo {
+	parts := strings.Split(host, ".")
+	if len(parts) < 6 || parts[1] != "dkr" || parts[2] != "ecr" || parts[4] != "amazonaws" {
+		return ecrHostInfo{}
+	}
+	return ecrHostInfo{Account: parts[0], Region: parts[3]}
+}
+
+// autoCreateName returns the configured name template for auto-created
+// resources, or the default of the token's provider
+func (r *Rancher) autoCreateName(token registryToken) string {
+	if r.AutoCreateName != "" {
+		return r.AutoCreateName
+	}
+	return autoCreateDefaultsFor(token.Provider).Name
+}
+
+// autoCreateDescription returns the configured description template for
+// auto-created resources, or the default of the token's provider
+func (r *Rancher) autoCreateDescription(token registryToken) string {
+	if r.AutoCreateDescription != "" {
+		return r.AutoCreateDescription
+	}
+	return autoCreateDefaultsFor(token.Provider).Description
+}
+
+// autoCreateDefaultsFor falls back to the ECR defaults for tokens that don't
+// name their provider
+func autoCreateDefaultsFor(provider string) struct{ Name, Description string } {
+	if defaults, ok := autoCreateDefaults[provider]; ok {
+		return defaults
+	}
+	return autoCreateDefaults["ecr"]
+}
+
+// expand replaces the {provider}, {account}, {region} and {host} placeholders
+// in a configured name or description. {account} and {region} are empty for
+// registries other than ECR.
+func (t registryToken) expand(template string) string {
+	return strings.NewReplacer(
+		"{provider}", t.Provider,
+		"{account}", t.Info.Account,
+		"{region}", t.Info.Region,
+		"{host}", t.Host,
+	).Replace(template)
+}
+
+// ownershipData builds the Data map recorded on auto-created registries.
+// ECR registries record their AWS account and region, others the registry
+// host. Rotations are only recorded on the credential, see credentialData.
+func (r *Rancher) ownershipData(token registryToken) map[string]interface{} {
+	timestamp := now().UTC().Format(time.RFC3339)
+	meta := map[string]interface{}{
+		"managedBy":  managedBy,
+		"instanceId": r.InstanceID,
+		"created":    timestamp,
+	}
+	if token.Provider != "" {
+		meta["provider"] = token.Provider
+	}
+	if token.Info.Account != "" {
+		meta["awsAccountId"] = token.Info.Account
+		meta["awsRegion"] = token.Info.Region
+	} else {
+		meta["host"] = token.Host
+	}
+	return map[string]interface{}{metadataKey: meta}
+}
+
+// isManaged reports whether a resource's Data map marks it as created by
+// this updater
+func isManaged(data map[string]interface{}) bool {
+	meta, ok := data[metadataKey].(map[string]interface{})
+	return ok && meta["managedBy"] == managedBy
+}
+
+// credentialData builds the Data map recorded on auto-created credentials:
+// the registry's ownership data plus the last rotation time
+func (r *Rancher) credentialData(token registryToken) map[string]interface{} {
+	return r.rotatedData(r.ownershipData(token))
+}
+
+// rotatedData returns a copy of a managed resource's Data map with the
+// last rotation time set to now
+func (r *Rancher) rotatedData(data map[string]interface{}) map[string]interface{} {
+	updated := map[string]interface{}{}
+	for k, v := range data {
+		updated[k] = v
+	}
+	meta := map[string]interface{}{}
+	for k, v := range data[metadataKey].(map[string]interface{}) {
+		meta[k] = v
+	}
+	meta["lastRotated"] = now().UTC().Format(time.RFC3339)
+	meta["instanceId"] = r.InstanceID
+	updated[metadataKey] = meta
+	return updated
+}
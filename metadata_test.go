@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadata_parseEcrHost(t *testing.T) {
+	info := parseEcrHost("012345678910.dkr.ecr.eu-west-1.amazonaws.com")
+	assert.Equal(t, ecrHostInfo{Account: "012345678910", Region: "eu-west-1"}, info)
+	token := registryToken{Provider: "ecr", Host: "unused", Info: info}
+	assert.Equal(t, "registry-012345678910-eu-west-1", token.expand("registry-{account}-{region}"))
+
+	assert.Equal(t, ecrHostInfo{}, parseEcrHost("registry.example.com"))
+}
+
+func TestMetadata_providerDefaults(t *testing.T) {
+	now = func() time.Time { return time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+	r := &Rancher{InstanceID: "updater-1"}
+	token := registryToken{Provider: "azure", Host: "myregistry.azurecr.io"}
+
+	assert.Equal(t, "acr-myregistry.azurecr.io", token.expand(r.autoCreateName(token)))
+	assert.Equal(t, "Azure Container Registry myregistry.azurecr.io, managed by rancher-ecr-credentials",
+		token.expand(r.autoCreateDescription(token)))
+	assert.Equal(t, map[string]interface{}{
+		"managedBy":  managedBy,
+		"provider":   "azure",
+		"host":       "myregistry.azurecr.io",
+		"instanceId": "updater-1",
+		"created":    "2017-07-01T12:00:00Z",
+	}, r.ownershipData(token)[metadataKey])
+	assert.Equal(t, "2017-07-01T12:00:00Z", r.credentialData(token)[metadataKey].(map[string]interface{})["lastRotated"])
+
+	// a configured name applies to every provider
+	r.AutoCreateName = "{provider}-{host}"
+	assert.Equal(t, "azure-myregistry.azurecr.io", token.expand(r.autoCreateName(token)))
+}
+
+func TestMetadata_rotatedData(t *testing.T) {
+	now = func() time.Time { return time.Date(2017, 7, 2, 0, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+	r := &Rancher{InstanceID: "updater-2"}
+
+	data := map[string]interface{}{
+		"other": "value",
+		metadataKey: map[string]interface{}{
+			"managedBy":   managedBy,
+			"created":     "2017-07-01T12:00:00Z",
+			"lastRotated": "2017-07-01T12:00:00Z",
+			"instanceId":  "updater-1",
+		},
+	}
+	assert.True(t, isManaged(data))
+	assert.False(t, isManaged(map[string]interface{}{"other": "value"}))
+
+	rotated := r.rotatedData(data)
+	assert.Equal(t, "value", rotated["other"])
+	meta := rotated[metadataKey].(map[string]interface{})
+	assert.Equal(t, "2017-07-01T12:00:00Z", meta["created"])
+	assert.Equal(t, "2017-07-02T00:00:00Z", meta["lastRotated"])
+	assert.Equal(t, "updater-2", meta["instanceId"])
+	// the original map is left untouched
+	assert.Equal(t, "updater-1", data[metadataKey].(map[string]interface{})["instanceId"])
+}
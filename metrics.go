@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricsRegistry is a minimal set of counters and gauges exposed in the
+// Prometheus text format
+type metricsRegistry struct {
+	mu     sync.Mutex
+	help   map[string]string
+	kinds  map[string]string
+	values map[string]map[string]float64
+}
+
+// metrics is the registry served on /metrics
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		help:   map[string]string{},
+		kinds:  map[string]string{},
+		values: map[string]map[string]float64{},
+	}
+}
+
+// Describe sets the type ("counter" or "gauge") and help text of a metric
+func (m *metricsRegistry) Describe(name, kind, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kinds[name] = kind
+	m.help[name] = help
+}
+
+// Inc adds one to a counter. Labels are given as name, value pairs.
+func (m *metricsRegistry) Inc(name string, labels ...string) {
+	m.Add(name, 1, labels...)
+}
+
+// Add adds delta to a counter
+func (m *metricsRegistry) Add(name string, delta float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(name)[formatLabels(labels)] += delta
+}
+
+// Set sets the value of a gauge
+func (m *metricsRegistry) Set(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(name)[formatLabels(labels)] = value
+}
+
+// Reset removes every series of a metric, for gauges whose label sets change
+// between collections
+func (m *metricsRegistry) Reset(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, name)
+}
+
+// Value returns the current value of a metric, mostly for tests
+func (m *metricsRegistry) Value(name string, labels ...string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[name][formatLabels(labels)]
+}
+
+func (m *metricsRegistry) series(name string) map[string]float64 {
+	s, ok := m.values[name]
+	if !ok {
+		s = map[string]float64{}
+		m.values[name] = s
+	}
+	return s
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	names := []string{}
+	for name := range m.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if help, ok := m.help[name]; ok {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, m.kinds[name])
+		}
+		series := []string{}
+		for labels := range m.values[name] {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+		for _, labels := range series {
+			fmt.Fprintf(w, "%s%s %g\n", name, labels, m.values[name][labels])
+		}
+	}
+}
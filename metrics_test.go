@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_serve(t *testing.T) {
+	m := newMetricsRegistry()
+	m.Describe("test_total", "counter", "A test counter.")
+	m.Inc("test_total", "operation", "list")
+	m.Add("test_total", 2, "operation", "list")
+	m.Set("test_gauge", 1.5)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, `test_gauge 1.5
+# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{operation="list"} 3
+`, w.Body.String())
+}
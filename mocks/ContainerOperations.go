@@ -0,0 +1,529 @@
+package mocks
+
+import client "github.com/rancher/go-rancher/client"
+import mock "github.com/stretchr/testify/mock"
+
+// ContainerOperations is an autogenerated mock type for the ContainerOperations type
+type ContainerOperations struct {
+	mock.Mock
+}
+
+// ActionAllocate provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionAllocate(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionConsole provides a mock function with given fields: _a0, _a1
+func (_m *ContainerOperations) ActionConsole(_a0 *client.Container, _a1 *client.InstanceConsoleInput) (*client.InstanceConsole, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *client.InstanceConsole
+	if rf, ok := ret.Get(0).(func(*client.Container, *client.InstanceConsoleInput) *client.InstanceConsole); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.InstanceConsole)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container, *client.InstanceConsoleInput) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionCreate provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionCreate(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionDeallocate provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionDeallocate(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionExecute provides a mock function with given fields: _a0, _a1
+func (_m *ContainerOperations) ActionExecute(_a0 *client.Container, _a1 *client.ContainerExec) (*client.HostAccess, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *client.HostAccess
+	if rf, ok := ret.Get(0).(func(*client.Container, *client.ContainerExec) *client.HostAccess); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.HostAccess)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container, *client.ContainerExec) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionLogs provides a mock function with given fields: _a0, _a1
+func (_m *ContainerOperations) ActionLogs(_a0 *client.Container, _a1 *client.ContainerLogs) (*client.HostAccess, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *client.HostAccess
+	if rf, ok := ret.Get(0).(func(*client.Container, *client.ContainerLogs) *client.HostAccess); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.HostAccess)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container, *client.ContainerLogs) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionMigrate provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionMigrate(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionPurge provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionPurge(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionRemove provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionRemove(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionRestart provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionRestart(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionRestore provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionRestore(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionSetlabels provides a mock function with given fields: _a0, _a1
+func (_m *ContainerOperations) ActionSetlabels(_a0 *client.Container, _a1 *client.SetLabelsInput) (*client.Container, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *client.Container
+	if rf, ok := ret.Get(0).(func(*client.Container, *client.SetLabelsInput) *client.Container); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Container)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container, *client.SetLabelsInput) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionStart provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionStart(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionStop provides a mock function with given fields: _a0, _a1
+func (_m *ContainerOperations) ActionStop(_a0 *client.Container, _a1 *client.InstanceStop) (*client.Instance, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container, *client.InstanceStop) *client.Instance); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container, *client.InstanceStop) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionUpdate provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionUpdate(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionUpdatehealthy provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionUpdatehealthy(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionUpdatereinitializing provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionUpdatereinitializing(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionUpdateunhealthy provides a mock function with given fields: _a0
+func (_m *ContainerOperations) ActionUpdateunhealthy(_a0 *client.Container) (*client.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Instance
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ById provides a mock function with given fields: id
+func (_m *ContainerOperations) ById(id string) (*client.Container, error) {
+	ret := _m.Called(id)
+
+	var r0 *client.Container
+	if rf, ok := ret.Get(0).(func(string) *client.Container); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Container)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Create provides a mock function with given fields: opts
+func (_m *ContainerOperations) Create(opts *client.Container) (*client.Container, error) {
+	ret := _m.Called(opts)
+
+	var r0 *client.Container
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.Container); ok {
+		r0 = rf(opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Container)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Delete provides a mock function with given fields: container
+func (_m *ContainerOperations) Delete(container *client.Container) error {
+	ret := _m.Called(container)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*client.Container) error); ok {
+		r0 = rf(container)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// List provides a mock function with given fields: opts
+func (_m *ContainerOperations) List(opts *client.ListOpts) (*client.ContainerCollection, error) {
+	ret := _m.Called(opts)
+
+	var r0 *client.ContainerCollection
+	if rf, ok := ret.Get(0).(func(*client.ListOpts) *client.ContainerCollection); ok {
+		r0 = rf(opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.ContainerCollection)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.ListOpts) error); ok {
+		r1 = rf(opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Update provides a mock function with given fields: existing, updates
+func (_m *ContainerOperations) Update(existing *client.Container, updates interface{}) (*client.Container, error) {
+	ret := _m.Called(existing, updates)
+
+	var r0 *client.Container
+	if rf, ok := ret.Get(0).(func(*client.Container, interface{}) *client.Container); ok {
+		r0 = rf(existing, updates)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Container)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container, interface{}) error); ok {
+		r1 = rf(existing, updates)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
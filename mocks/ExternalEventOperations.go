@@ -0,0 +1,161 @@
+package mocks
+
+import client "github.com/rancher/go-rancher/client"
+import mock "github.com/stretchr/testify/mock"
+
+// ExternalEventOperations is an autogenerated mock type for the ExternalEventOperations type
+type ExternalEventOperations struct {
+	mock.Mock
+}
+
+// ActionCreate provides a mock function with given fields: _a0
+func (_m *ExternalEventOperations) ActionCreate(_a0 *client.ExternalEvent) (*client.ExternalEvent, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.ExternalEvent
+	if rf, ok := ret.Get(0).(func(*client.ExternalEvent) *client.ExternalEvent); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.ExternalEvent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.ExternalEvent) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionRemove provides a mock function with given fields: _a0
+func (_m *ExternalEventOperations) ActionRemove(_a0 *client.ExternalEvent) (*client.ExternalEvent, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.ExternalEvent
+	if rf, ok := ret.Get(0).(func(*client.ExternalEvent) *client.ExternalEvent); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.ExternalEvent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.ExternalEvent) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ById provides a mock function with given fields: id
+func (_m *ExternalEventOperations) ById(id string) (*client.ExternalEvent, error) {
+	ret := _m.Called(id)
+
+	var r0 *client.ExternalEvent
+	if rf, ok := ret.Get(0).(func(string) *client.ExternalEvent); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.ExternalEvent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Create provides a mock function with given fields: opts
+func (_m *ExternalEventOperations) Create(opts *client.ExternalEvent) (*client.ExternalEvent, error) {
+	ret := _m.Called(opts)
+
+	var r0 *client.ExternalEvent
+	if rf, ok := ret.Get(0).(func(*client.ExternalEvent) *client.ExternalEvent); ok {
+		r0 = rf(opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.ExternalEvent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.ExternalEvent) error); ok {
+		r1 = rf(opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Delete provides a mock function with given fields: container
+func (_m *ExternalEventOperations) Delete(container *client.ExternalEvent) error {
+	ret := _m.Called(container)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*client.ExternalEvent) error); ok {
+		r0 = rf(container)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// List provides a mock function with given fields: opts
+func (_m *ExternalEventOperations) List(opts *client.ListOpts) (*client.ExternalEventCollection, error) {
+	ret := _m.Called(opts)
+
+	var r0 *client.ExternalEventCollection
+	if rf, ok := ret.Get(0).(func(*client.ListOpts) *client.ExternalEventCollection); ok {
+		r0 = rf(opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.ExternalEventCollection)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.ListOpts) error); ok {
+		r1 = rf(opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Update provides a mock function with given fields: existing, updates
+func (_m *ExternalEventOperations) Update(existing *client.ExternalEvent, updates interface{}) (*client.ExternalEvent, error) {
+	ret := _m.Called(existing, updates)
+
+	var r0 *client.ExternalEvent
+	if rf, ok := ret.Get(0).(func(*client.ExternalEvent, interface{}) *client.ExternalEvent); ok {
+		r0 = rf(existing, updates)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.ExternalEvent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.ExternalEvent, interface{}) error); ok {
+		r1 = rf(existing, updates)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
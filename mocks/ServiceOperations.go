@@ -0,0 +1,437 @@
+package mocks
+
+import client "github.com/rancher/go-rancher/client"
+import mock "github.com/stretchr/testify/mock"
+
+// ServiceOperations is an autogenerated mock type for the ServiceOperations type
+type ServiceOperations struct {
+	mock.Mock
+}
+
+// ActionActivate provides a mock function with given fields: _a0
+func (_m *ServiceOperations) ActionActivate(_a0 *client.Service) (*client.Service, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionAddservicelink provides a mock function with given fields: _a0, _a1
+func (_m *ServiceOperations) ActionAddservicelink(_a0 *client.Service, _a1 *client.AddRemoveServiceLinkInput) (*client.Service, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service, *client.AddRemoveServiceLinkInput) *client.Service); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service, *client.AddRemoveServiceLinkInput) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionCancelrollback provides a mock function with given fields: _a0
+func (_m *ServiceOperations) ActionCancelrollback(_a0 *client.Service) (*client.Service, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionCancelupgrade provides a mock function with given fields: _a0
+func (_m *ServiceOperations) ActionCancelupgrade(_a0 *client.Service) (*client.Service, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionCreate provides a mock function with given fields: _a0
+func (_m *ServiceOperations) ActionCreate(_a0 *client.Service) (*client.Service, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionDeactivate provides a mock function with given fields: _a0
+func (_m *ServiceOperations) ActionDeactivate(_a0 *client.Service) (*client.Service, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionFinishupgrade provides a mock function with given fields: _a0
+func (_m *ServiceOperations) ActionFinishupgrade(_a0 *client.Service) (*client.Service, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionRemove provides a mock function with given fields: _a0
+func (_m *ServiceOperations) ActionRemove(_a0 *client.Service) (*client.Service, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionRemoveservicelink provides a mock function with given fields: _a0, _a1
+func (_m *ServiceOperations) ActionRemoveservicelink(_a0 *client.Service, _a1 *client.AddRemoveServiceLinkInput) (*client.Service, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service, *client.AddRemoveServiceLinkInput) *client.Service); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service, *client.AddRemoveServiceLinkInput) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionRestart provides a mock function with given fields: _a0, _a1
+func (_m *ServiceOperations) ActionRestart(_a0 *client.Service, _a1 *client.ServiceRestart) (*client.Service, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service, *client.ServiceRestart) *client.Service); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service, *client.ServiceRestart) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionRollback provides a mock function with given fields: _a0
+func (_m *ServiceOperations) ActionRollback(_a0 *client.Service) (*client.Service, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionSetservicelinks provides a mock function with given fields: _a0, _a1
+func (_m *ServiceOperations) ActionSetservicelinks(_a0 *client.Service, _a1 *client.SetServiceLinksInput) (*client.Service, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service, *client.SetServiceLinksInput) *client.Service); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service, *client.SetServiceLinksInput) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionUpdate provides a mock function with given fields: _a0
+func (_m *ServiceOperations) ActionUpdate(_a0 *client.Service) (*client.Service, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActionUpgrade provides a mock function with given fields: _a0, _a1
+func (_m *ServiceOperations) ActionUpgrade(_a0 *client.Service, _a1 *client.ServiceUpgrade) (*client.Service, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service, *client.ServiceUpgrade) *client.Service); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service, *client.ServiceUpgrade) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ById provides a mock function with given fields: id
+func (_m *ServiceOperations) ById(id string) (*client.Service, error) {
+	ret := _m.Called(id)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(string) *client.Service); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Create provides a mock function with given fields: opts
+func (_m *ServiceOperations) Create(opts *client.Service) (*client.Service, error) {
+	ret := _m.Called(opts)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Delete provides a mock function with given fields: container
+func (_m *ServiceOperations) Delete(container *client.Service) error {
+	ret := _m.Called(container)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*client.Service) error); ok {
+		r0 = rf(container)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// List provides a mock function with given fields: opts
+func (_m *ServiceOperations) List(opts *client.ListOpts) (*client.ServiceCollection, error) {
+	ret := _m.Called(opts)
+
+	var r0 *client.ServiceCollection
+	if rf, ok := ret.Get(0).(func(*client.ListOpts) *client.ServiceCollection); ok {
+		r0 = rf(opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.ServiceCollection)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.ListOpts) error); ok {
+		r1 = rf(opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Update provides a mock function with given fields: existing, updates
+func (_m *ServiceOperations) Update(existing *client.Service, updates interface{}) (*client.Service, error) {
+	ret := _m.Called(existing, updates)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service, interface{}) *client.Service); ok {
+		r0 = rf(existing, updates)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service, interface{}) error); ok {
+		r1 = rf(existing, updates)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_webhook_sent_total", "counter", "Webhook notifications delivered.")
+	metrics.Describe("ecr_updater_webhook_failures_total", "counter", "Webhook notifications that could not be delivered.")
+	metrics.Describe("ecr_updater_webhook_dropped_total", "counter", "Webhook notifications dropped by the rate limit.")
+}
+
+// Notification events
+const (
+	eventFailing   = "failing"
+	eventExpiring  = "expiring"
+	eventRecovered = "recovered"
+	eventMissing   = "imageMissing"
+)
+
+// Webhook payload formats
+const (
+	webhookJSON  = "json"
+	webhookSlack = "slack"
+)
+
+// notification is the data sent to webhooks and given to WEBHOOK_TEMPLATE
+type notification struct {
+	Event     string     `json:"event"`
+	Target    string     `json:"target"`
+	Failures  int        `json:"consecutiveFailures,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Services  []string   `json:"services,omitempty"`
+	Instance  string     `json:"instance,omitempty"`
+	Time      time.Time  `json:"time"`
+	Message   string     `json:"message"`
+}
+
+// targetHealth tracks one target across update cycles
+type targetHealth struct {
+	failures  int
+	lastError string
+	alerted   bool
+	expiresAt time.Time
+	// warnedFor is the expiry an expiring event was last sent for
+	warnedFor time.Time
+}
+
+// notifier posts to webhooks when a target keeps failing, when its credential
+// is about to expire without having been refreshed, and when it recovers.
+// Each event is sent once per occurrence, and at most RateLimit are sent per
+// hour.
+type notifier struct {
+	URLs   []string
+	Format string
+	// Template, if set, renders the request body
+	Template *template.Template
+	// FailureThreshold is the number of consecutive failures that trigger an
+	// event
+	FailureThreshold int
+	// ExpiryWarning is how long before expiry an unrefreshed credential
+	// triggers an event
+	ExpiryWarning time.Duration
+	// CheckInterval is how often Run looks for expiring credentials
+	CheckInterval time.Duration
+	RateLimit     int
+	Instance      string
+	client        *http.Client
+
+	mu      sync.Mutex
+	targets map[string]*targetHealth
+	// missing holds the images an imageMissing event was sent for
+	missing map[string]bool
+	sent    []time.Time
+}
+
+func newNotifier(urls []string, format string) *notifier {
+	return &notifier{
+		URLs:             urls,
+		Format:           format,
+		FailureThreshold: 3,
+		ExpiryWarning:    time.Hour,
+		CheckInterval:    5 * time.Minute,
+		RateLimit:        20,
+		client:           &http.Client{Timeout: 10 * time.Second},
+		targets:          map[string]*targetHealth{},
+		missing:          map[string]bool{},
+	}
+}
+
+// parseWebhookTemplate parses a body template. The json function quotes a
+// value for use inside a JSON document.
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// Seed records the expiry of tokens synced before a restart
+func (n *notifier) Seed(state *stateStore) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for target, t := range state.Targets() {
+		n.health(target).expiresAt = t.ExpiresAt
+	}
+}
+
+// Observe updates the targets from the report of an update cycle and sends
+// the resulting events
+func (n *notifier) Observe(rep *report) {
+	if n == nil || rep == nil {
+		return
+	}
+	n.mu.Lock()
+	var events []notification
+	refreshed := map[string]bool{}
+	for _, result := range rep.Results {
+		switch result.Status {
+		case statusUpdated, statusCreated, statusUnchanged:
+			refreshed[result.Endpoint] = true
+			h := n.health(result.Endpoint)
+			events = n.recovered(events, result.Endpoint)
+			if info := parseEcrHost(strings.TrimPrefix(result.Endpoint, "https://")); info.Account != "" {
+				// failures seen before any endpoint of the registry was known
+				events = n.recovered(events, info.Account)
+				events = n.recovered(events, "ecr")
+			}
+			if result.ExpiresAt != nil {
+				h.expiresAt = *result.ExpiresAt
+			}
+		case statusFailed, statusTimeout:
+			events = n.failure(events, result.Endpoint, result.Error)
+		}
+	}
+	for _, failure := range rep.ProviderFailures {
+		for _, target := range n.failureTargets(failure, refreshed) {
+			events = n.failure(events, target, failure.Error)
+		}
+	}
+	events = append(events, n.expiring(refreshed)...)
+	n.mu.Unlock()
+
+	for _, event := range events {
+		n.send(event)
+	}
+}
+
+// Run checks for expiring credentials every CheckInterval while active
+// returns true, until ctx is done, so a warning goes out even when no update
+// cycle completes in time
+func (n *notifier) Run(ctx context.Context, active func() bool) {
+	ticker := time.NewTicker(n.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if !active() {
+			continue
+		}
+		n.mu.Lock()
+		events := n.expiring(nil)
+		n.mu.Unlock()
+		for _, event := range events {
+			n.send(event)
+		}
+	}
+}
+
+// failureTargets returns the targets a token failure applies to: the known
+// endpoints of the failing registry, so failures and recoveries are counted
+// for the same target. Until an endpoint of the registry has been seen, the
+// registry ID stands in for it.
+func (n *notifier) failureTargets(failure providerFailure, refreshed map[string]bool) []string {
+	if failure.Provider != "" && failure.RegistryID != "" {
+		return []string{"https://" + failure.RegistryID}
+	}
+	var targets []string
+	for target := range n.targets {
+		info := parseEcrHost(strings.TrimPrefix(target, "https://"))
+		if info.Account == "" || refreshed[target] {
+			continue
+		}
+		// without a registry ID the request covered every registry
+		if failure.RegistryID == "" || failure.RegistryID == info.Account {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) > 0 {
+		sort.Strings(targets)
+		return targets
+	}
+	if failure.RegistryID != "" {
+		return []string{failure.RegistryID}
+	}
+	return []string{"ecr"}
+}
+
+// expiring returns an expiring event for each credential about to expire that
+// was not refreshed. n.mu must be held.
+func (n *notifier) expiring(refreshed map[string]bool) []notification {
+	var events []notification
+	deadline := now().Add(n.ExpiryWarning)
+	for target, h := range n.targets {
+		if refreshed[target] || h.expiresAt.IsZero() || h.expiresAt.After(deadline) || h.warnedFor.Equal(h.expiresAt) {
+			continue
+		}
+		h.warnedFor = h.expiresAt
+		expiresAt := h.expiresAt
+		events = append(events, notification{
+			Event:     eventExpiring,
+			Target:    target,
+			Failures:  h.failures,
+			Error:     h.lastError,
+			ExpiresAt: &expiresAt,
+			Message:   fmt.Sprintf("ECR credential for %s expires at %s and has not been refreshed", target, expiresAt.Format(time.RFC3339)),
+		})
+	}
+	return events
+}
+
+// ImagesMissing sends an event for each image found missing by an image
+// audit. An image is reported again only after it was found in between;
+// images the audit could not check keep their previous state.
+func (n *notifier) ImagesMissing(rep *auditReport) {
+	if n == nil || rep == nil {
+		return
+	}
+	n.mu.Lock()
+	var events []notification
+	current := map[string]bool{}
+	for image := range n.missing {
+		// forget images that were checked, or that no service uses anymore
+		if !rep.checked[image] && (rep.referenced == nil || rep.referenced[image]) {
+			current[image] = true
+		}
+	}
+	for _, m := range rep.Missing {
+		current[m.Image] = true
+		if n.missing[m.Image] {
+			continue
+		}
+		events = append(events, notification{
+			Event:    eventMissing,
+			Target:   m.Image,
+			Services: m.Services,
+			Error:    m.Code,
+			Message:  fmt.Sprintf("Image %s used by %s does not exist in ECR: %s", m.Image, strings.Join(m.Services, ", "), m.Code),
+		})
+	}
+	n.missing = current
+	n.mu.Unlock()
+
+	for _, event := range events {
+		n.send(event)
+	}
+}
+
+// failure counts a failed refresh and returns events with a failing event
+// appended once the threshold is reached
+func (n *notifier) failure(events []notification, target, errMsg string) []notification {
+	h := n.health(target)
+	h.failures++
+	h.lastError = errMsg
+	if h.alerted || h.failures < n.FailureThreshold {
+		return events
+	}
+	h.alerted = true
+	return append(events, notification{
+		Event:    eventFailing,
+		Target:   target,
+		Failures: h.failures,
+		Error:    errMsg,
+		Message:  fmt.Sprintf("ECR credential refresh for %s failed %d times in a row: %s", target, h.failures, errMsg),
+	})
+}
+
+// recovered resets a target after a successful refresh and returns events
+// with a recovered event appended if it was reported failing
+func (n *notifier) recovered(events []notification, target string) []notification {
+	h, ok := n.targets[target]
+	if !ok {
+		return events
+	}
+	if h.alerted {
+		events = append(events, notification{
+			Event:    eventRecovered,
+			Target:   target,
+			Failures: h.failures,
+			Message:  fmt.Sprintf("ECR credential refresh for %s recovered after %d failures", target, h.failures),
+		})
+	}
+	h.failures, h.alerted, h.lastError = 0, false, ""
+	return events
+}
+
+func (n *notifier) health(target string) *targetHealth {
+	h, ok := n.targets[target]
+	if !ok {
+		h = &targetHealth{}
+		n.targets[target] = h
+	}
+	return h
+}
+
+// allow applies the hourly rate limit
+func (n *notifier) allow() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	t := now()
+	recent := n.sent[:0]
+	for _, s := range n.sent {
+		if t.Sub(s) < time.Hour {
+			recent = append(recent, s)
+		}
+	}
+	n.sent = recent
+	if n.RateLimit > 0 && len(n.sent) >= n.RateLimit {
+		return false
+	}
+	n.sent = append(n.sent, t)
+	return true
+}
+
+func (n *notifier) send(event notification) {
+	event.Instance = n.Instance
+	event.Time = now()
+	if !n.allow() {
+		log.Warnf("[webhook] Rate limit reached, dropping %s event for %s", event.Event, event.Target)
+		metrics.Inc("ecr_updater_webhook_dropped_total", "event", event.Event)
+		return
+	}
+	body, err := n.payload(event)
+	if err != nil {
+		log.Printf("[webhook] Unable to render %s event for %s: %s\n", event.Event, event.Target, err)
+		metrics.Inc("ecr_updater_webhook_failures_total", "event", event.Event)
+		return
+	}
+	for _, url := range n.URLs {
+		if err := n.post(url, body); err != nil {
+			log.Printf("[webhook] Error sending %s event for %s: %s\n", event.Event, event.Target, err)
+			metrics.Inc("ecr_updater_webhook_failures_total", "event", event.Event)
+			continue
+		}
+		metrics.Inc("ecr_updater_webhook_sent_total", "event", event.Event)
+	}
+}
+
+func (n *notifier) payload(event notification) ([]byte, error) {
+	if n.Template != nil {
+		var buf bytes.Buffer
+		err := n.Template.Execute(&buf, event)
+		return buf.Bytes(), err
+	}
+	if n.Format == webhookSlack {
+		return json.Marshal(map[string]string{"text": event.Message})
+	}
+	return json.Marshal(event)
+}
+
+func (n *notifier) post(url string, body []byte) error {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook returned %s", resp.Status)
+	}
+	return nil
+}
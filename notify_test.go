@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookReceiver records the bodies posted to it
+type webhookReceiver struct {
+	mu     sync.Mutex
+	bodies [][]byte
+}
+
+func (w *webhookReceiver) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	b, _ := ioutil.ReadAll(req.Body)
+	w.mu.Lock()
+	w.bodies = append(w.bodies, b)
+	w.mu.Unlock()
+}
+
+func (w *webhookReceiver) events(t *testing.T) []notification {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var events []notification
+	for _, b := range w.bodies {
+		var n notification
+		assert.NoError(t, json.Unmarshal(b, &n))
+		events = append(events, n)
+	}
+	return events
+}
+
+func failedReport(endpoint string) *report {
+	return &report{Results: []targetResult{{Endpoint: endpoint, Status: statusFailed, Error: "boom"}}}
+}
+
+func TestNotify_failureAndRecovery(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	n := newNotifier([]string{server.URL}, webhookJSON)
+	n.FailureThreshold = 2
+
+	n.Observe(failedReport(testEndpoint))
+	assert.Empty(t, receiver.events(t))
+	n.Observe(failedReport(testEndpoint))
+	// further failures don't repeat the event
+	n.Observe(failedReport(testEndpoint))
+	n.Observe(&report{Results: []targetResult{{Endpoint: testEndpoint, Status: statusUpdated}}})
+
+	events := receiver.events(t)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, eventFailing, events[0].Event)
+		assert.Equal(t, 2, events[0].Failures)
+		assert.Equal(t, "boom", events[0].Error)
+		assert.Equal(t, eventRecovered, events[1].Event)
+		assert.Equal(t, 3, events[1].Failures)
+	}
+}
+
+func TestNotify_expiring(t *testing.T) {
+	current := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	n := newNotifier([]string{server.URL}, webhookJSON)
+
+	expiresAt := current.Add(12 * time.Hour)
+	n.Observe(&report{Results: []targetResult{{Endpoint: testEndpoint, Status: statusUpdated, ExpiresAt: &expiresAt}}})
+	current = current.Add(11 * time.Hour)
+	// AWS is down, so nothing is refreshed
+	n.Observe(&report{ProviderFailures: []providerFailure{{Class: errClassServer, Error: "unavailable"}}})
+	n.Observe(&report{ProviderFailures: []providerFailure{{Class: errClassServer, Error: "unavailable"}}})
+
+	events := receiver.events(t)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, eventExpiring, events[0].Event)
+		assert.Equal(t, testEndpoint, events[0].Target)
+		assert.Equal(t, expiresAt, *events[0].ExpiresAt)
+	}
+}
+
+func TestNotify_providerFailureRecoversEndpoint(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	n := newNotifier([]string{server.URL}, webhookJSON)
+	n.FailureThreshold = 2
+
+	n.Observe(&report{Results: []targetResult{{Endpoint: testEndpoint, Status: statusUpdated}}})
+	failure := &report{ProviderFailures: []providerFailure{{RegistryID: "012345678910", Class: errClassAccessDenied, Error: "denied"}}}
+	n.Observe(failure)
+	n.Observe(failure)
+	n.Observe(&report{Results: []targetResult{{Endpoint: testEndpoint, Status: statusUpdated}}})
+
+	events := receiver.events(t)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, eventFailing, events[0].Event)
+		assert.Equal(t, testEndpoint, events[0].Target)
+		assert.Equal(t, eventRecovered, events[1].Event)
+		assert.Equal(t, testEndpoint, events[1].Target)
+	}
+}
+
+func TestNotify_runChecksExpiry(t *testing.T) {
+	current := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	n := newNotifier([]string{server.URL}, webhookJSON)
+	n.CheckInterval = time.Millisecond
+
+	expiresAt := current.Add(30 * time.Minute)
+	n.Observe(&report{Results: []targetResult{{Endpoint: testEndpoint, Status: statusUpdated, ExpiresAt: &expiresAt}}})
+	assert.Empty(t, receiver.events(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx, func() bool { return true })
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(receiver.events(t)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	events := receiver.events(t)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, eventExpiring, events[0].Event)
+		assert.Equal(t, testEndpoint, events[0].Target)
+	}
+}
+
+func TestNotify_rateLimit(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	n := newNotifier([]string{server.URL}, webhookJSON)
+	n.FailureThreshold = 1
+	n.RateLimit = 2
+
+	n.Observe(&report{Results: []targetResult{
+		{Endpoint: "a", Status: statusFailed, Error: "boom"},
+		{Endpoint: "b", Status: statusFailed, Error: "boom"},
+		{Endpoint: "c", Status: statusTimeout, Error: "slow"},
+	}})
+
+	assert.Len(t, receiver.events(t), 2)
+}
+
+func TestNotify_slackAndTemplate(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	n := newNotifier([]string{server.URL}, webhookSlack)
+	n.FailureThreshold = 1
+
+	n.Observe(failedReport(testEndpoint))
+	tmpl, err := parseWebhookTemplate(`{"summary": {{json .Message}}, "kind": "{{.Event}}"}`)
+	assert.NoError(t, err)
+	n.Template = tmpl
+	n.Observe(failedReport("other"))
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if assert.Len(t, receiver.bodies, 2) {
+		var slack map[string]string
+		assert.NoError(t, json.Unmarshal(receiver.bodies[0], &slack))
+		assert.Contains(t, slack["text"], testEndpoint)
+		var custom map[string]string
+		assert.NoError(t, json.Unmarshal(receiver.bodies[1], &custom))
+		assert.Equal(t, eventFailing, custom["kind"])
+		assert.Contains(t, custom["summary"], "failed 1 times")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_credential_mismatches", "gauge", "Services whose launch config does not reference the managed registry credential.")
+	metrics.Describe("ecr_updater_credential_pins_total", "counter", "Services upgraded to reference the managed registry credential.")
+}
+
+const stateUpgraded = "upgraded"
+
+// credentialPinner finds services whose image is on a managed registry but
+// whose launch config references another registry credential, or none. With
+// Upgrade set they are upgraded in place to reference the managed credential.
+type credentialPinner struct {
+	Upgrade bool
+	// MaxUpgrades bounds the upgrades started per cycle
+	MaxUpgrades int
+
+	rancher       *Rancher
+	serviceClient client.ServiceOperations
+	retrier       *rancherRetrier
+}
+
+// Pin checks every service against the credentials synced in a cycle
+func (p *credentialPinner) Pin(ctx context.Context, rep *report) {
+	if p == nil || rep == nil {
+		return
+	}
+	credentials := map[string]string{}
+	for _, result := range rep.Results {
+		switch result.Status {
+		case statusUpdated, statusCreated, statusUnchanged:
+			if result.CredentialID != "" {
+				credentials[result.Host] = result.CredentialID
+			}
+		}
+	}
+	if len(credentials) == 0 {
+		return
+	}
+	services, err := listServices(p.serviceClient, &client.ListOpts{})
+	if err != nil {
+		log.Printf("[pin] Failed to list services: %s\n", err)
+		return
+	}
+
+	mismatches, upgrades := 0, 0
+	for i := range services {
+		service := &services[i]
+		if service.LaunchConfig == nil {
+			continue
+		}
+		want, ok := credentials[imageHost(service.LaunchConfig.ImageUuid)]
+		if !ok || service.LaunchConfig.RegistryCredentialId == want {
+			continue
+		}
+		mismatches++
+		log.Printf("[pin] Service %s (%s) references registry credential %q instead of %s\n",
+			service.Name, service.Id, service.LaunchConfig.RegistryCredentialId, want)
+		if !p.Upgrade || ctx.Err() != nil {
+			continue
+		}
+		if service.State != stateActive {
+			log.Printf("[pin] Not upgrading service %s in state %s\n", service.Id, service.State)
+			continue
+		}
+		if p.MaxUpgrades > 0 && upgrades >= p.MaxUpgrades {
+			log.Warnf("[pin] Reached the limit of %d upgrades for this cycle", p.MaxUpgrades)
+			continue
+		}
+		upgrades++
+		if err := p.pin(ctx, service, want); err != nil {
+			log.Printf("[pin] Failed to upgrade service %s: %s\n", service.Id, err)
+			continue
+		}
+		mismatches--
+		metrics.Inc("ecr_updater_credential_pins_total")
+	}
+	metrics.Set("ecr_updater_credential_mismatches", float64(mismatches))
+}
+
+// pin upgrades a service in place to use credentialID and finishes the
+// upgrade once it completes
+func (p *credentialPinner) pin(ctx context.Context, service *client.Service, credentialID string) error {
+	launchConfig := *service.LaunchConfig
+	launchConfig.RegistryCredentialId = credentialID
+	log.Printf("[pin] Upgrading service %s to registry credential %s\n", service.Id, credentialID)
+	var upgraded *client.Service
+	err := p.retrier.do("service.upgrade", false, func() error {
+		var err error
+		upgraded, err = p.serviceClient.ActionUpgrade(service, &client.ServiceUpgrade{
+			InServiceStrategy: &client.InServiceUpgradeStrategy{
+				BatchSize:      1,
+				IntervalMillis: 2000,
+				LaunchConfig:   &launchConfig,
+			},
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if upgraded == nil {
+		upgraded = service
+	}
+	if p.rancher.TransitionTimeout <= 0 {
+		log.Printf("[pin] Not waiting for service %s to finish upgrading\n", service.Id)
+		return nil
+	}
+	_, err = p.rancher.waitForState(ctx, stateUpgraded, serviceState(upgraded), func() (resourceState, error) {
+		current, err := p.serviceClient.ById(service.Id)
+		if err != nil {
+			return resourceState{}, err
+		}
+		if current == nil {
+			return resourceState{}, fmt.Errorf("Service %s was removed", service.Id)
+		}
+		upgraded = current
+		return serviceState(current), nil
+	})
+	if err != nil {
+		return err
+	}
+	return p.retrier.do("service.finishupgrade", false, func() error {
+		_, err := p.serviceClient.ActionFinishupgrade(upgraded)
+		return err
+	})
+}
+
+func serviceState(service *client.Service) resourceState {
+	return resourceState{
+		State:                service.State,
+		Transitioning:        service.Transitioning,
+		TransitioningMessage: service.TransitioningMessage,
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func syncedReport() *report {
+	return &report{Results: []targetResult{{Endpoint: testEndpoint, Host: ecrHost, CredentialID: "1rc2", Status: statusUnchanged}}}
+}
+
+func TestPin_reportsMismatches(t *testing.T) {
+	mockServices := new(mocks.ServiceOperations)
+	mockServices.On("List", &client.ListOpts{}).Return(&client.ServiceCollection{Data: []client.Service{
+		{Resource: client.Resource{Id: "1s1"}, State: stateActive, LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/app:1", RegistryCredentialId: "1rc1"}},
+		{Resource: client.Resource{Id: "1s2"}, State: stateActive, LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/app:1"}},
+		{Resource: client.Resource{Id: "1s3"}, State: stateActive, LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/app:1", RegistryCredentialId: "1rc2"}},
+		{Resource: client.Resource{Id: "1s4"}, State: stateActive, LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:nginx"}},
+	}}, nil)
+	p := &credentialPinner{rancher: &Rancher{}, serviceClient: mockServices}
+
+	p.Pin(context.Background(), syncedReport())
+
+	mockServices.AssertNotCalled(t, "ActionUpgrade", mock.Anything, mock.Anything)
+	assert.Equal(t, 2.0, metrics.Value("ecr_updater_credential_mismatches"))
+}
+
+func TestPin_upgradesService(t *testing.T) {
+	mockServices := new(mocks.ServiceOperations)
+	service := client.Service{
+		Resource:     client.Resource{Id: "1s1"},
+		State:        stateActive,
+		LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/app:1", RegistryCredentialId: "1rc1"},
+	}
+	mockServices.On("List", &client.ListOpts{}).Return(&client.ServiceCollection{Data: []client.Service{service}}, nil)
+	upgrading := &client.Service{Resource: client.Resource{Id: "1s1"}, State: "upgrading", Transitioning: "yes"}
+	mockServices.On("ActionUpgrade", &service, mock.MatchedBy(func(u *client.ServiceUpgrade) bool {
+		return u.InServiceStrategy.LaunchConfig.RegistryCredentialId == "1rc2" &&
+			u.InServiceStrategy.LaunchConfig.ImageUuid == service.LaunchConfig.ImageUuid
+	})).Return(upgrading, nil)
+	upgraded := &client.Service{Resource: client.Resource{Id: "1s1"}, State: stateUpgraded, Transitioning: "no"}
+	mockServices.On("ById", "1s1").Return(upgraded, nil)
+	mockServices.On("ActionFinishupgrade", upgraded).Return(&client.Service{}, nil)
+	p := &credentialPinner{
+		Upgrade:       true,
+		rancher:       &Rancher{TransitionTimeout: time.Second, TransitionPollInterval: time.Millisecond},
+		serviceClient: mockServices,
+		retrier:       testRetrier(1, 0),
+	}
+
+	p.Pin(context.Background(), syncedReport())
+
+	mockServices.AssertExpectations(t)
+	assert.Equal(t, 0.0, metrics.Value("ecr_updater_credential_mismatches"))
+	// the original launch config is left untouched
+	assert.Equal(t, "1rc1", service.LaunchConfig.RegistryCredentialId)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_provider_errors_total", "counter", "Failed token requests to registries other than ECR, by provider and class of error.")
+}
+
+// Classes of token provider and AWS API failures, reported separately in logs,
+// status and metrics
+const (
+	errClassThrottling      = "throttling"
+	errClassServer          = "server"
+	errClassCredentials     = "credentials"
+	errClassAccessDenied    = "access_denied"
+	errClassInvalidRegistry = "invalid_registry"
+	errClassOther           = "other"
+)
+
+// providerFailure records a registry no token could be retrieved for, along
+// with the provider that failed. For ECR, RegistryID is the registry ID and is
+// empty for the account's default registry; other providers name the registry
+// host.
+type providerFailure struct {
+	Provider   string `json:"provider,omitempty"`
+	RegistryID string `json:"registryId,omitempty"`
+	Class      string `json:"class"`
+	Code       string `json:"code,omitempty"`
+	Error      string `json:"error"`
+}
+
+// registryAuth is a short-lived login for a container registry
+type registryAuth struct {
+	// Provider is the name of the TokenProvider the login came from
+	Provider string
+	// Endpoint is the URL of the registry, e.g. https://gcr.io
+	Endpoint string
+	// Auth is the base64 encoded <user>:<password>, as in a Docker config
+	Auth      string
+	ExpiresAt *time.Time
+}
+
+// TokenProvider retrieves the registry logins synced into Rancher. Failures
+// are reported per registry so the logins that were retrieved still are.
+type TokenProvider interface {
+	Name() string
+	Tokens(ctx context.Context) ([]registryAuth, []providerFailure)
+}
+
+// ecrProvider gets authorization tokens from Amazon ECR for the configured or
+// discovered registry IDs
+type ecrProvider struct {
+	rancher *Rancher
+	svc     ecriface.ECRAPI
+	// ecrClient, if set, returns the client for a registry ID in the default
+	// region, so IDs with an endpoint of their own are requested through it
+	ecrClient func(account, region string) ecriface.ECRAPI
+}
+
+func (p *ecrProvider) Name() string {
+	return "ecr"
+}
+
+func (p *ecrProvider) Tokens(ctx context.Context) ([]registryAuth, []providerFailure) {
+	var authData []*ecr.AuthorizationData
+	var failures []providerFailure
+	if p.rancher.Discovery != nil {
+		authData, failures = p.rancher.discoverAuthorizationData(ctx, p.svc)
+	} else {
+		authData, failures = p.rancher.getAuthorizationDataByClient(ctx, p.svc, p.ecrClient, "", p.rancher.RegistryIds)
+	}
+	if len(authData) > 0 {
+		log.Println("Returned from AWS GetAuthorizationToken call successfully")
+	}
+	tokens := make([]registryAuth, 0, len(authData))
+	for _, data := range authData {
+		auth := registryAuth{ExpiresAt: data.ExpiresAt}
+		if data.ProxyEndpoint != nil {
+			auth.Endpoint = *data.ProxyEndpoint
+		}
+		if data.AuthorizationToken != nil {
+			auth.Auth = *data.AuthorizationToken
+		}
+		tokens = append(tokens, auth)
+	}
+	return tokens, failures
+}
+
+// httpStatusError is returned for an unsuccessful response from a token
+// endpoint
+type httpStatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s returned %d: %s", e.URL, e.StatusCode, e.Body)
+}
+
+// newProviderFailure logs and counts a failed token request, classifying it
+// like the AWS errors
+func newProviderFailure(provider, registry string, err error) providerFailure {
+	class := errClassServer
+	if e, ok := err.(*httpStatusError); ok {
+		switch {
+		case e.StatusCode == 401 || e.StatusCode == 403:
+			class = errClassCredentials
+		case e.StatusCode == 429:
+			class = errClassThrottling
+		case e.StatusCode < 500:
+			class = errClassOther
+		}
+	}
+	metrics.Inc("ecr_updater_provider_errors_total", "provider", provider, "class", class)
+	log.WithFields(log.Fields{
+		"class":    class,
+		"provider": provider,
+		"registry": registry,
+	}).Errorf("Error requesting registry token: %s", err)
+	return providerFailure{
+		Provider:   provider,
+		RegistryID: registry,
+		Class:      class,
+		Error:      err.Error(),
+	}
+}
+
+// postForm posts a form to a token endpoint and decodes its JSON response
+// into out
+func postForm(ctx context.Context, httpClient *http.Client, endpoint string, form url.Values, out interface{}) error {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &httpStatusError{URL: endpoint, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	return json.Unmarshal(body, out)
+}
+
+// basicAuth encodes a login the way registryAuth expects it
+func basicAuth(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
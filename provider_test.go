@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// writeServiceAccountKey writes a service account key file whose tokens are
+// requested from tokenURI
+func writeServiceAccountKey(t *testing.T, dir, tokenURI string) (string, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	b, err := json.Marshal(gcpServiceAccount{
+		ClientEmail:  "updater@project.iam.gserviceaccount.com",
+		PrivateKeyID: "key-1",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:     tokenURI,
+	})
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "key.json")
+	assert.NoError(t, ioutil.WriteFile(path, b, 0600))
+	return path, key
+}
+
+func TestProvider_gcp(t *testing.T) {
+	var claims map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.NoError(t, req.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", req.Form.Get("grant_type"))
+		parts := strings.Split(req.Form.Get("assertion"), ".")
+		if assert.Len(t, parts, 3) {
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			json.Unmarshal(payload, &claims)
+		}
+		w.Write([]byte(`{"access_token": "gcp-token", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "gcp")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyFile, _ := writeServiceAccountKey(t, dir, server.URL)
+
+	p, err := newGcpProvider(keyFile, []string{"us-docker.pkg.dev", "gcr.io"})
+	assert.NoError(t, err)
+	tokens, failures := p.Tokens(context.Background())
+
+	assert.Empty(t, failures)
+	assert.Equal(t, "updater@project.iam.gserviceaccount.com", claims["iss"])
+	assert.Equal(t, server.URL, claims["aud"])
+	if assert.Len(t, tokens, 2) {
+		assert.Equal(t, "https://us-docker.pkg.dev", tokens[0].Endpoint)
+		assert.Equal(t, basicAuth("oauth2accesstoken", "gcp-token"), tokens[0].Auth)
+		assert.NotNil(t, tokens[0].ExpiresAt)
+	}
+}
+
+func TestProvider_gcpDenied(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, `{"error": "invalid_grant"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "gcp")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyFile, _ := writeServiceAccountKey(t, dir, server.URL)
+
+	p, err := newGcpProvider(keyFile, []string{"gcr.io"})
+	assert.NoError(t, err)
+	tokens, failures := p.Tokens(context.Background())
+
+	assert.Empty(t, tokens)
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, "gcp", failures[0].Provider)
+		assert.Equal(t, "gcr.io", failures[0].RegistryID)
+		assert.Equal(t, errClassCredentials, failures[0].Class)
+	}
+}
+
+func TestProvider_acr(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant-1/oauth2/v2.0/token", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		assert.Equal(t, "client_credentials", req.Form.Get("grant_type"))
+		assert.Equal(t, "client-1", req.Form.Get("client_id"))
+		w.Write([]byte(`{"access_token": "aad-token"}`))
+	})
+	mux.HandleFunc("/oauth2/exchange", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		assert.Equal(t, "aad-token", req.Form.Get("access_token"))
+		w.Write([]byte(`{"refresh_token": "acr-refresh-token"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	p := newAcrProvider([]string{registry}, "tenant-1", "client-1", "secret")
+	p.Authority = server.URL
+	p.scheme = "http"
+	tokens, failures := p.Tokens(context.Background())
+
+	assert.Empty(t, failures)
+	if assert.Len(t, tokens, 1) {
+		assert.Equal(t, "https://"+registry, tokens[0].Endpoint)
+		assert.Equal(t, basicAuth(acrUsername, "acr-refresh-token"), tokens[0].Auth)
+	}
+}
+
+// staticProvider returns fixed tokens
+type staticProvider []registryAuth
+
+func (p staticProvider) Name() string { return "static" }
+
+func (p staticProvider) Tokens(ctx context.Context) ([]registryAuth, []providerFailure) {
+	return p, nil
+}
+
+func TestProvider_syncsAnyProvider(t *testing.T) {
+	r := &Rancher{ProxyHost: "ecr-proxy.example.com"}
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{
+		Data: []client.Registry{{Resource: client.Resource{Id: "1r1"}, ServerAddress: "gcr.io"}},
+	}, nil)
+	mockRegistryCredential.On("List", mock.Anything).Return(&client.RegistryCredentialCollection{
+		Data: []client.RegistryCredential{{Resource: client.Resource{Id: "1rc1"}, RegistryId: "1r1"}},
+	}, nil)
+	mockRegistryCredential.On("Update", mock.Anything, &client.RegistryCredential{
+		PublicValue: gcpUsername,
+		SecretValue: "gcp-token",
+		Email:       "not-really@required.anymore",
+	}).Return(&client.RegistryCredential{}, nil)
+
+	rep := r.update(context.Background(), []TokenProvider{staticProvider{
+		{Endpoint: "https://gcr.io", Auth: basicAuth(gcpUsername, "gcp-token")},
+	}}, mockRegistry, mockRegistryCredential)
+
+	mockRegistryCredential.AssertExpectations(t)
+	// ECR_PROXY_HOST only applies to ECR registries
+	assert.Equal(t, "gcr.io", rep.Results[0].Host)
+	assert.Equal(t, statusUpdated, rep.Results[0].Status)
+}
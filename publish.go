@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+)
+
+// statusKey is the key in a registry's data holding the outcome of its last
+// refresh
+const statusKey = "rancherEcrCredentialsStatus"
+
+// statusPublisher makes the outcome of each refresh visible in Rancher: in
+// the registry's data and description, and as external events
+type statusPublisher struct {
+	// Data records the outcome under statusKey in the registry's data
+	Data bool
+	// Description replaces the registry's description with a summary
+	Description bool
+	// Events creates an external event for every outcome
+	Events     bool
+	InstanceID string
+
+	registryClient client.RegistryOperations
+	eventClient    client.ExternalEventOperations
+	retrier        *rancherRetrier
+}
+
+// Publish records every result of a cycle that changed or failed to change a
+// credential. Unchanged credentials are left alone so they are not rewritten.
+func (p *statusPublisher) Publish(rep *report) {
+	if p == nil || rep == nil {
+		return
+	}
+	for _, result := range rep.Results {
+		switch result.Status {
+		case statusUpdated, statusCreated, statusFailed, statusTimeout:
+		default:
+			continue
+		}
+		if result.RegistryID == "" {
+			continue
+		}
+		if p.Data || p.Description {
+			if err := p.updateRegistry(result); err != nil {
+				log.Printf("[%s] Error publishing status on registry %s: %s\n", result.Endpoint, result.RegistryID, err)
+			}
+		}
+		if p.Events {
+			if err := p.createEvent(result); err != nil {
+				log.Printf("[%s] Error creating external event for registry %s: %s\n", result.Endpoint, result.RegistryID, err)
+			}
+		}
+	}
+}
+
+func (p *statusPublisher) updateRegistry(result targetResult) error {
+	registry, err := p.registryClient.ById(result.RegistryID)
+	if err != nil {
+		return err
+	}
+	if registry == nil {
+		return fmt.Errorf("Registry %s not found", result.RegistryID)
+	}
+	timestamp := now().UTC().Format(time.RFC3339)
+	status := map[string]interface{}{
+		"status":      result.Status,
+		"lastAttempt": timestamp,
+		"instanceId":  p.InstanceID,
+	}
+	// keep the time of the last successful rotation across failures
+	if previous, ok := registry.Data[statusKey].(map[string]interface{}); ok {
+		if lastRotated, ok := previous["lastRotated"]; ok {
+			status["lastRotated"] = lastRotated
+		}
+	}
+	description := fmt.Sprintf("ECR credentials rotated at %s", timestamp)
+	if result.Error != "" {
+		status["error"] = result.Error
+		description = fmt.Sprintf("ECR credential refresh %s at %s: %s", result.Status, timestamp, result.Error)
+	} else {
+		status["lastRotated"] = timestamp
+	}
+
+	updates := &client.Registry{}
+	if p.Data {
+		data := map[string]interface{}{}
+		for k, v := range registry.Data {
+			data[k] = v
+		}
+		data[statusKey] = status
+		updates.Data = data
+	}
+	if p.Description {
+		updates.Description = description
+	}
+	_, err = p.registryClient.Update(registry, updates)
+	return err
+}
+
+func (p *statusPublisher) createEvent(result targetResult) error {
+	event := &client.ExternalEvent{
+		EventType:  metadataKey + "." + result.Status,
+		ExternalId: result.RegistryID,
+		Data: map[string]interface{}{
+			"endpoint":     result.Endpoint,
+			"registryId":   result.RegistryID,
+			"credentialId": result.CredentialID,
+			"status":       result.Status,
+			"error":        result.Error,
+			"instanceId":   p.InstanceID,
+		},
+	}
+	return p.retrier.do("externalEvent.create", false, func() error {
+		_, err := p.eventClient.Create(event)
+		return err
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPublish_registryStatus(t *testing.T) {
+	now = func() time.Time { return time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+	mockRegistry := new(mocks.RegistryOperations)
+	registry := &client.Registry{
+		Resource: client.Resource{Id: "1r1"},
+		Data: map[string]interface{}{
+			"other": "kept",
+			statusKey: map[string]interface{}{
+				"lastRotated": "2017-06-30T12:00:00Z",
+			},
+		},
+	}
+	mockRegistry.On("ById", "1r1").Return(registry, nil)
+	mockRegistry.On("Update", registry, &client.Registry{
+		Description: "ECR credential refresh failed at 2017-07-01T12:00:00Z: boom",
+		Data: map[string]interface{}{
+			"other": "kept",
+			statusKey: map[string]interface{}{
+				"status":      statusFailed,
+				"error":       "boom",
+				"lastAttempt": "2017-07-01T12:00:00Z",
+				"lastRotated": "2017-06-30T12:00:00Z",
+				"instanceId":  "updater-1",
+			},
+		},
+	}).Return(registry, nil)
+	p := &statusPublisher{Data: true, Description: true, InstanceID: "updater-1", registryClient: mockRegistry}
+
+	p.Publish(&report{Results: []targetResult{
+		{Endpoint: testEndpoint, RegistryID: "1r1", Status: statusFailed, Error: "boom"},
+		// unchanged credentials are not rewritten
+		{Endpoint: "other", RegistryID: "1r2", Status: statusUnchanged},
+	}})
+
+	mockRegistry.AssertExpectations(t)
+}
+
+func TestPublish_events(t *testing.T) {
+	mockEvents := new(mocks.ExternalEventOperations)
+	mockEvents.On("Create", mock.MatchedBy(func(e *client.ExternalEvent) bool {
+		return e.EventType == "rancherEcrCredentials.created" && e.ExternalId == "1r1" && e.Data["credentialId"] == "1rc1"
+	})).Return(&client.ExternalEvent{}, nil)
+	p := &statusPublisher{Events: true, eventClient: mockEvents, retrier: testRetrier(1, 0)}
+
+	p.Publish(&report{Results: []targetResult{
+		{Endpoint: testEndpoint, RegistryID: "1r1", CredentialID: "1rc1", Status: statusCreated},
+	}})
+
+	mockEvents.AssertExpectations(t)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_repository_policies_updated_total", "counter", "Repository policies changed to grant or revoke pull access.")
+}
+
+// pullPolicySid identifies the statement the updater manages in a repository
+// policy; other statements are left as they are
+const pullPolicySid = "RancherEcrCredentialsPull"
+
+// defaultPullActions are the actions needed to pull an image
+var defaultPullActions = []string{
+	"ecr:BatchCheckLayerAvailability",
+	"ecr:BatchGetImage",
+	"ecr:GetDownloadUrlForLayer",
+}
+
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// pullRule grants Principals pull access to Repositories of the registry
+// Account in Region. A repository ending in * matches every repository with
+// that prefix.
+type pullRule struct {
+	Account      string   `json:"account"`
+	Region       string   `json:"region"`
+	Repositories []string `json:"repositories"`
+	// Principals are account IDs or IAM ARNs
+	Principals []string `json:"principals"`
+	// Actions default to defaultPullActions
+	Actions []string `json:"actions,omitempty"`
+}
+
+func (p pullRule) matches(repository string) bool {
+	for _, pattern := range p.Repositories {
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(repository, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+		if pattern == repository {
+			return true
+		}
+	}
+	return false
+}
+
+// pullPolicyManager converges the pull statement of the repositories named in
+// Rules
+type pullPolicyManager struct {
+	Rules  []pullRule
+	DryRun bool
+
+	ecrClient func(account, region string) ecriface.ECRAPI
+}
+
+// loadPullRules reads the rules from a JSON file
+func loadPullRules(path string) ([]pullRule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []pullRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %s", path, err)
+	}
+	for i, rule := range rules {
+		if rule.Account == "" || rule.Region == "" || len(rule.Repositories) == 0 || len(rule.Principals) == 0 {
+			return nil, fmt.Errorf("Rule %d in %s needs an account, region, repositories and principals", i, path)
+		}
+	}
+	return rules, nil
+}
+
+// Converge updates the policy of every repository matched by a rule, and
+// removes the managed statement from repositories no rule matches anymore
+func (m *pullPolicyManager) Converge(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	registries := map[ecrHostInfo][]pullRule{}
+	for _, rule := range m.Rules {
+		info := ecrHostInfo{Account: rule.Account, Region: rule.Region}
+		registries[info] = append(registries[info], rule)
+	}
+	for info, rules := range registries {
+		if ctx.Err() != nil {
+			return
+		}
+		svc := m.ecrClient(info.Account, info.Region)
+		existing, err := describeRepositories(svc, info.Account)
+		if err != nil {
+			newAwsCallFailure("DescribeRepositories", info.Account, err)
+			continue
+		}
+		var names []string
+		for name := range existing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			m.apply(svc, info, name, pullStatement(rules, name))
+		}
+	}
+}
+
+// pullStatement returns the statement granting the principals of the rules
+// matching repository, or nil if none do
+func pullStatement(rules []pullRule, repository string) map[string]interface{} {
+	principals := map[string]bool{}
+	actions := map[string]bool{}
+	for _, rule := range rules {
+		if !rule.matches(repository) {
+			continue
+		}
+		for _, principal := range rule.Principals {
+			if accountIDPattern.MatchString(principal) {
+				principal = fmt.Sprintf("arn:aws:iam::%s:root", principal)
+			}
+			principals[principal] = true
+		}
+		ruleActions := rule.Actions
+		if len(ruleActions) == 0 {
+			ruleActions = defaultPullActions
+		}
+		for _, action := range ruleActions {
+			actions[action] = true
+		}
+	}
+	if len(principals) == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"Sid":       pullPolicySid,
+		"Effect":    "Allow",
+		"Principal": map[string]interface{}{"AWS": sortedKeys(principals)},
+		"Action":    sortedKeys(actions),
+	}
+}
+
+// apply replaces the managed statement of a repository's policy if it differs
+// from statement, or removes it if statement is nil. A policy left without
+// statements is deleted. Failures are logged and counted.
+func (m *pullPolicyManager) apply(svc ecriface.ECRAPI, info ecrHostInfo, name string, statement map[string]interface{}) {
+	policy := map[string]interface{}{"Version": "2008-10-17"}
+	resp, err := svc.GetRepositoryPolicy(&ecr.GetRepositoryPolicyInput{
+		RegistryId:     aws.String(info.Account),
+		RepositoryName: aws.String(name),
+	})
+	if err != nil {
+		if _, code := classifyAwsError(err); code != "RepositoryPolicyNotFoundException" {
+			newAwsCallFailure("GetRepositoryPolicy", info.Account, err)
+			return
+		}
+	} else if err := json.Unmarshal([]byte(aws.StringValue(resp.PolicyText)), &policy); err != nil {
+		log.Printf("[policies] Unable to parse policy of repository %s in %s/%s: %s\n", name, info.Account, info.Region, err)
+		return
+	}
+
+	// a policy with a single statement may give it as an object rather than
+	// a list
+	var existing []interface{}
+	switch st := policy["Statement"].(type) {
+	case nil:
+	case []interface{}:
+		existing = st
+	case map[string]interface{}:
+		existing = []interface{}{st}
+	default:
+		log.Printf("[policies] Unrecognised Statement in policy of repository %s in %s/%s, leaving it alone\n", name, info.Account, info.Region)
+		return
+	}
+	var current interface{}
+	statements := []interface{}{}
+	for _, s := range existing {
+		if sm, ok := s.(map[string]interface{}); ok && sm["Sid"] == pullPolicySid {
+			current = s
+			continue
+		}
+		statements = append(statements, s)
+	}
+	if statement == nil {
+		if current != nil {
+			m.remove(svc, info, name, policy, statements, current)
+		}
+		return
+	}
+	if samePullStatement(current, statement) {
+		return
+	}
+	policy["Statement"] = append(statements, statement)
+
+	before, _ := json.Marshal(current)
+	after, _ := json.Marshal(statement)
+	if m.DryRun {
+		log.Printf("[policies] Would change pull statement of %s in %s/%s\n- %s\n+ %s\n", name, info.Account, info.Region, before, after)
+		return
+	}
+	b, err := json.Marshal(policy)
+	if err != nil {
+		log.Printf("[policies] Unable to encode policy of repository %s in %s/%s: %s\n", name, info.Account, info.Region, err)
+		return
+	}
+	log.Printf("[policies] Changing pull statement of %s in %s/%s\n- %s\n+ %s\n", name, info.Account, info.Region, before, after)
+	_, err = svc.SetRepositoryPolicy(&ecr.SetRepositoryPolicyInput{
+		RegistryId:     aws.String(info.Account),
+		RepositoryName: aws.String(name),
+		PolicyText:     aws.String(string(b)),
+	})
+	if err != nil {
+		newAwsCallFailure("SetRepositoryPolicy", info.Account, err)
+		return
+	}
+	metrics.Inc("ecr_updater_repository_policies_updated_total")
+}
+
+// remove drops the managed statement current from a repository's policy,
+// keeping the other statements, or deletes the policy if none are left
+func (m *pullPolicyManager) remove(svc ecriface.ECRAPI, info ecrHostInfo, name string, policy map[string]interface{}, statements []interface{}, current interface{}) {
+	before, _ := json.Marshal(current)
+	if m.DryRun {
+		log.Printf("[policies] Would remove pull statement of %s in %s/%s, no rule matches it\n- %s\n", name, info.Account, info.Region, before)
+		return
+	}
+	log.Printf("[policies] Removing pull statement of %s in %s/%s, no rule matches it\n- %s\n", name, info.Account, info.Region, before)
+	if len(statements) == 0 {
+		_, err := svc.DeleteRepositoryPolicy(&ecr.DeleteRepositoryPolicyInput{
+			RegistryId:     aws.String(info.Account),
+			RepositoryName: aws.String(name),
+		})
+		if err != nil {
+			newAwsCallFailure("DeleteRepositoryPolicy", info.Account, err)
+			return
+		}
+		metrics.Inc("ecr_updater_repository_policies_updated_total")
+		return
+	}
+	policy["Statement"] = statements
+	b, err := json.Marshal(policy)
+	if err != nil {
+		log.Printf("[policies] Unable to encode policy of repository %s in %s/%s: %s\n", name, info.Account, info.Region, err)
+		return
+	}
+	_, err = svc.SetRepositoryPolicy(&ecr.SetRepositoryPolicyInput{
+		RegistryId:     aws.String(info.Account),
+		RepositoryName: aws.String(name),
+		PolicyText:     aws.String(string(b)),
+	})
+	if err != nil {
+		newAwsCallFailure("SetRepositoryPolicy", info.Account, err)
+		return
+	}
+	metrics.Inc("ecr_updater_repository_policies_updated_total")
+}
+
+// samePullStatement compares a statement read from a policy with the wanted
+// one. AWS may return a single principal or action as a string rather than a
+// list, so those are compared as sets.
+func samePullStatement(current interface{}, want map[string]interface{}) bool {
+	cm, ok := current.(map[string]interface{})
+	if !ok || cm["Effect"] != want["Effect"] {
+		return false
+	}
+	principal, _ := cm["Principal"].(map[string]interface{})
+	wantPrincipal := want["Principal"].(map[string]interface{})
+	return sameStrings(principal["AWS"], wantPrincipal["AWS"].([]string)) &&
+		sameStrings(cm["Action"], want["Action"].([]string))
+}
+
+// sameStrings reports whether v, a string or list of strings, holds exactly
+// want
+func sameStrings(v interface{}, want []string) bool {
+	var got []string
+	switch value := v.(type) {
+	case string:
+		got = []string{value}
+	case []interface{}:
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return false
+			}
+			got = append(got, s)
+		}
+	}
+	sort.Strings(got)
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testPullRules = []pullRule{{
+	Account:      "012345678910",
+	Region:       "us-east-1",
+	Repositories: []string{"team/*", "shared"},
+	Principals:   []string{"111111111111", "arn:aws:iam::222222222222:role/pull"},
+}}
+
+func pullPolicyEcr(policies map[string]string) *mocks.ECRAPI {
+	mockEcr := new(mocks.ECRAPI)
+	mockEcr.On("DescribeRepositories", &ecr.DescribeRepositoriesInput{RegistryId: aws.String("012345678910")}).Return(
+		&ecr.DescribeRepositoriesOutput{Repositories: []*ecr.Repository{
+			{RepositoryName: aws.String("team/app")},
+			{RepositoryName: aws.String("shared")},
+			{RepositoryName: aws.String("private")},
+		}}, nil)
+	if _, ok := policies["private"]; !ok {
+		policies["private"] = ""
+	}
+	for name, policy := range policies {
+		input := &ecr.GetRepositoryPolicyInput{RegistryId: aws.String("012345678910"), RepositoryName: aws.String(name)}
+		if policy == "" {
+			mockEcr.On("GetRepositoryPolicy", input).Return(nil, awserr.New("RepositoryPolicyNotFoundException", "no policy", nil))
+			continue
+		}
+		mockEcr.On("GetRepositoryPolicy", input).Return(&ecr.GetRepositoryPolicyOutput{PolicyText: aws.String(policy)}, nil)
+	}
+	return mockEcr
+}
+
+func TestPullPolicy_converges(t *testing.T) {
+	// shared already grants the principals, in another order
+	current := `{"Version": "2008-10-17", "Statement": [{"Sid": "RancherEcrCredentialsPull", "Effect": "Allow",
+		"Principal": {"AWS": ["arn:aws:iam::222222222222:role/pull", "arn:aws:iam::111111111111:root"]},
+		"Action": ["ecr:GetDownloadUrlForLayer", "ecr:BatchGetImage", "ecr:BatchCheckLayerAvailability"]}]}`
+	// team/app has an unmanaged statement and an outdated managed one
+	outdated := `{"Version": "2008-10-17", "Statement": [{"Sid": "CI", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::333333333333:root"}, "Action": "ecr:*"},
+		{"Sid": "RancherEcrCredentialsPull", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::111111111111:root"}, "Action": "ecr:BatchGetImage"}]}`
+	mockEcr := pullPolicyEcr(map[string]string{"shared": current, "team/app": outdated})
+	var written string
+	mockEcr.On("SetRepositoryPolicy", mock.Anything).Run(func(args mock.Arguments) {
+		input := args.Get(0).(*ecr.SetRepositoryPolicyInput)
+		assert.Equal(t, "team/app", aws.StringValue(input.RepositoryName))
+		written = aws.StringValue(input.PolicyText)
+	}).Return(&ecr.SetRepositoryPolicyOutput{}, nil).Once()
+	m := &pullPolicyManager{Rules: testPullRules, ecrClient: func(string, string) ecriface.ECRAPI { return mockEcr }}
+
+	m.Converge(context.Background())
+
+	mockEcr.AssertExpectations(t)
+	var policy struct {
+		Statement []map[string]interface{}
+	}
+	assert.NoError(t, json.Unmarshal([]byte(written), &policy))
+	if assert.Len(t, policy.Statement, 2) {
+		assert.Equal(t, "CI", policy.Statement[0]["Sid"])
+		assert.Equal(t, pullPolicySid, policy.Statement[1]["Sid"])
+		assert.Equal(t, map[string]interface{}{"AWS": []interface{}{"arn:aws:iam::111111111111:root", "arn:aws:iam::222222222222:role/pull"}},
+			policy.Statement[1]["Principal"])
+	}
+}
+
+func TestPullPolicy_dryRun(t *testing.T) {
+	mockEcr := pullPolicyEcr(map[string]string{"shared": "", "team/app": ""})
+	m := &pullPolicyManager{Rules: testPullRules, DryRun: true, ecrClient: func(string, string) ecriface.ECRAPI { return mockEcr }}
+
+	m.Converge(context.Background())
+
+	mockEcr.AssertExpectations(t)
+	mockEcr.AssertNotCalled(t, "SetRepositoryPolicy", mock.Anything)
+}
+
+func TestPullPolicy_removesUnmatched(t *testing.T) {
+	managed := `{"Sid": "RancherEcrCredentialsPull", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::111111111111:root"}, "Action": "ecr:BatchGetImage"}`
+	mockEcr := pullPolicyEcr(map[string]string{
+		"shared": "",
+		// team/app keeps its unmanaged statement
+		"team/app": `{"Version": "2008-10-17", "Statement": [{"Sid": "CI", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::333333333333:root"}, "Action": "ecr:*"}, ` + managed + `]}`,
+		"private":  `{"Version": "2008-10-17", "Statement": [` + managed + `]}`,
+	})
+	rules := []pullRule{{Account: "012345678910", Region: "us-east-1", Repositories: []string{"shared"}, Principals: []string{"111111111111"}}}
+	mockEcr.On("SetRepositoryPolicy", mock.MatchedBy(func(input *ecr.SetRepositoryPolicyInput) bool {
+		return aws.StringValue(input.RepositoryName) == "shared"
+	})).Return(&ecr.SetRepositoryPolicyOutput{}, nil).Once()
+	var written string
+	mockEcr.On("SetRepositoryPolicy", mock.MatchedBy(func(input *ecr.SetRepositoryPolicyInput) bool {
+		return aws.StringValue(input.RepositoryName) == "team/app"
+	})).Run(func(args mock.Arguments) {
+		written = aws.StringValue(args.Get(0).(*ecr.SetRepositoryPolicyInput).PolicyText)
+	}).Return(&ecr.SetRepositoryPolicyOutput{}, nil).Once()
+	mockEcr.On("DeleteRepositoryPolicy", &ecr.DeleteRepositoryPolicyInput{
+		RegistryId:     aws.String("012345678910"),
+		RepositoryName: aws.String("private"),
+	}).Return(&ecr.DeleteRepositoryPolicyOutput{}, nil).Once()
+	m := &pullPolicyManager{Rules: rules, ecrClient: func(string, string) ecriface.ECRAPI { return mockEcr }}
+
+	m.Converge(context.Background())
+
+	mockEcr.AssertExpectations(t)
+	var policy struct {
+		Statement []map[string]interface{}
+	}
+	assert.NoError(t, json.Unmarshal([]byte(written), &policy))
+	if assert.Len(t, policy.Statement, 1) {
+		assert.Equal(t, "CI", policy.Statement[0]["Sid"])
+	}
+}
+
+func TestPullPolicy_singleStatementObject(t *testing.T) {
+	mockEcr := pullPolicyEcr(map[string]string{
+		// shared already grants the principals, as the only statement
+		"shared": `{"Version": "2008-10-17", "Statement": {"Sid": "RancherEcrCredentialsPull", "Effect": "Allow",
+			"Principal": {"AWS": ["arn:aws:iam::111111111111:root", "arn:aws:iam::222222222222:role/pull"]},
+			"Action": ["ecr:BatchCheckLayerAvailability", "ecr:BatchGetImage", "ecr:GetDownloadUrlForLayer"]}}`,
+		// team/app has a single unmanaged statement that must be kept
+		"team/app": `{"Version": "2008-10-17", "Statement": {"Sid": "CI", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::333333333333:root"}, "Action": "ecr:*"}}`,
+		// private can't be parsed and is left alone
+		"private": `{"Version": "2008-10-17", "Statement": "unexpected"}`,
+	})
+	var written string
+	mockEcr.On("SetRepositoryPolicy", mock.Anything).Run(func(args mock.Arguments) {
+		input := args.Get(0).(*ecr.SetRepositoryPolicyInput)
+		assert.Equal(t, "team/app", aws.StringValue(input.RepositoryName))
+		written = aws.StringValue(input.PolicyText)
+	}).Return(&ecr.SetRepositoryPolicyOutput{}, nil).Once()
+	rules := append([]pullRule{}, testPullRules...)
+	rules[0].Repositories = append(rules[0].Repositories, "private")
+	m := &pullPolicyManager{Rules: rules, ecrClient: func(string, string) ecriface.ECRAPI { return mockEcr }}
+
+	m.Converge(context.Background())
+
+	mockEcr.AssertExpectations(t)
+	var policy struct {
+		Statement []map[string]interface{}
+	}
+	assert.NoError(t, json.Unmarshal([]byte(written), &policy))
+	if assert.Len(t, policy.Statement, 2) {
+		assert.Equal(t, "CI", policy.Statement[0]["Sid"])
+		assert.Equal(t, pullPolicySid, policy.Statement[1]["Sid"])
+	}
+}
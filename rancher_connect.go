@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+)
+
+// connectionState tracks whether the Rancher API client has been created yet
+type connectionState struct {
+	mu        sync.Mutex
+	waiting   bool
+	lastError string
+}
+
+// rancherConnection is reported on /ping and /status until the client exists
+var rancherConnection = &connectionState{}
+
+func (s *connectionState) setWaiting(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waiting = true
+	s.lastError = err.Error()
+}
+
+func (s *connectionState) setConnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waiting = false
+	s.lastError = ""
+}
+
+// Waiting reports whether the client could not be created yet, and why
+func (s *connectionState) Waiting() (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiting, s.lastError
+}
+
+// connectRancher creates the Rancher API client, retrying with backoff until
+// it succeeds or ctx is done. Creating the client fetches the API schemas, so
+// every attempt fetches them again.
+func connectRancher(ctx context.Context, b *backoff, newClient func() (*client.RancherClient, error)) (*client.RancherClient, error) {
+	for attempt := 1; ; attempt++ {
+		rancher, err := newClient()
+		if err == nil {
+			rancherConnection.setConnected()
+			return rancher, nil
+		}
+		rancherConnection.setWaiting(err)
+		log.Printf("[rancher] Unable to create Rancher API client, attempt %d: %s\n", attempt, err)
+		if err := b.Wait(ctx, attempt, false); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// untilInterrupted returns a context that is cancelled by SIGTERM or SIGINT
+// on signals, for waiting before the daemon handles them itself. stop ends the
+// wait and reports whether it was interrupted; signals arriving after it are
+// left for the daemon.
+func untilInterrupted(signals <-chan os.Signal) (ctx context.Context, stop func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	interrupted := false
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGUSR1 {
+					log.Info("Received SIGUSR1 before connecting to Rancher, ignoring")
+					continue
+				}
+				log.Infof("Received %s, shutting down", sig)
+				interrupted = true
+				cancel()
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ctx, func() bool {
+		cancel()
+		<-done
+		return interrupted
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnect_retriesUntilRancherIsUp(t *testing.T) {
+	defer rancherConnection.setConnected()
+	b := newBackoff(0, time.Second)
+	attempts := 0
+	b.sleep = func(time.Duration) {
+		// the health check reports the wait in the meantime
+		waiting, reason := rancherConnection.Waiting()
+		assert.True(t, waiting)
+		assert.Equal(t, "connection refused", reason)
+		rec := httptest.NewRecorder()
+		ping(rec, httptest.NewRequest("GET", "/ping", nil))
+		assert.Equal(t, "waiting for Rancher", rec.Body.String())
+	}
+
+	rancher, err := connectRancher(context.Background(), b, func() (*client.RancherClient, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return &client.RancherClient{}, nil
+	})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, rancher)
+	assert.Equal(t, 3, attempts)
+	waiting, _ := rancherConnection.Waiting()
+	assert.False(t, waiting)
+}
+
+func TestConnect_stopsWhenCancelled(t *testing.T) {
+	defer rancherConnection.setConnected()
+	ctx, cancel := context.WithCancel(context.Background())
+	b := newBackoff(0, time.Second)
+	b.sleep = func(time.Duration) { cancel() }
+
+	_, err := connectRancher(ctx, b, func() (*client.RancherClient, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestConnect_untilInterrupted(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	ctx, stop := untilInterrupted(signals)
+	signals <- syscall.SIGUSR1
+	signals <- syscall.SIGTERM
+	<-ctx.Done()
+	assert.True(t, stop())
+
+	// once stopped, signals are left for the daemon
+	ctx, stop = untilInterrupted(signals)
+	assert.False(t, stop())
+	signals <- syscall.SIGTERM
+	assert.Equal(t, syscall.SIGTERM, <-signals)
+	assert.Error(t, ctx.Err())
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_rancher_retries_total", "counter", "Rancher API calls retried after a transient failure.")
+	metrics.Describe("ecr_updater_rancher_failures_total", "counter", "Rancher API calls that failed after all retries.")
+	metrics.Describe("ecr_updater_rancher_circuit_open_total", "counter", "Times the Rancher API circuit breaker opened.")
+	metrics.Describe("ecr_updater_rancher_circuit_state", "gauge", "Rancher API circuit breaker state (0 closed, 1 open, 2 half-open).")
+}
+
+// rancherRetrier retries transient Rancher API failures and trips a circuit
+// breaker shared by every wrapped client when the server looks to be down.
+// The go-rancher operations take no context, so retries stop once the
+// retrier's own context is done.
+type rancherRetrier struct {
+	ctx     context.Context
+	backoff *backoff
+	breaker *circuitBreaker
+}
+
+func newRancherRetrier(ctx context.Context, b *backoff, breaker *circuitBreaker) *rancherRetrier {
+	breaker.onChange = func(state int) {
+		metrics.Set("ecr_updater_rancher_circuit_state", float64(state))
+		if state == circuitOpen {
+			metrics.Inc("ecr_updater_rancher_circuit_open_total")
+			log.Warnf("Rancher API circuit breaker opened, pausing calls for %s", breaker.Cooldown)
+		}
+	}
+	return &rancherRetrier{ctx: ctx, backoff: b, breaker: breaker}
+}
+
+// do calls fn, retrying with backoff when it fails transiently. Calls that
+// are not idempotent are only retried when the server throttled them, as the
+// request was then never processed.
+func (rr *rancherRetrier) do(operation string, idempotent bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := rr.breaker.Allow(); err != nil {
+			metrics.Inc("ecr_updater_rancher_failures_total", "operation", operation)
+			return err
+		}
+		err := fn()
+		throttled := isThrottled(err)
+		transient := isTransient(err)
+		rr.breaker.Record(!transient)
+		if err == nil {
+			return nil
+		}
+		if !(throttled || (transient && idempotent)) || attempt >= rr.backoff.Attempts {
+			metrics.Inc("ecr_updater_rancher_failures_total", "operation", operation)
+			return err
+		}
+		metrics.Inc("ecr_updater_rancher_retries_total", "operation", operation)
+		log.Debugf("Retrying Rancher %s after attempt %d failed: %s", operation, attempt, err)
+		if rr.backoff.Wait(rr.ctx, attempt, throttled) != nil {
+			metrics.Inc("ecr_updater_rancher_failures_total", "operation", operation)
+			return err
+		}
+	}
+}
+
+// isThrottled reports whether the Rancher API rejected a call with 429
+func isThrottled(err error) bool {
+	apiErr, ok := err.(*client.ApiError)
+	return ok && apiErr.StatusCode == 429
+}
+
+// isTransient reports whether an error means the Rancher server is
+// unavailable: a 5xx response or a network failure
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *client.ApiError:
+		return e.StatusCode >= 500
+	case *url.Error:
+		return true
+	case net.Error:
+		return true
+	}
+	return strings.Contains(err.Error(), "connection reset") || strings.Contains(err.Error(), "connection refused")
+}
+
+// retryingRegistryClient wraps client.RegistryOperations with retries
+type retryingRegistryClient struct {
+	ops     client.RegistryOperations
+	retrier *rancherRetrier
+}
+
+func (c *retryingRegistryClient) List(opts *client.ListOpts) (resp *client.RegistryCollection, err error) {
+	err = c.retrier.do("registry.list", true, func() error {
+		resp, err = c.ops.List(opts)
+		return err
+	})
+	return
+}
+
+func (c *retryingRegistryClient) Create(registry *client.Registry) (resp *client.Registry, err error) {
+	err = c.retrier.do("registry.create", false, func() error {
+		resp, err = c.ops.Create(registry)
+		return err
+	})
+	return
+}
+
+func (c *retryingRegistryClient) Update(existing *client.Registry, updates interface{}) (resp *client.Registry, err error) {
+	err = c.retrier.do("registry.update", true, func() error {
+		resp, err = c.ops.Update(existing, updates)
+		return err
+	})
+	return
+}
+
+func (c *retryingRegistryClient) ById(id string) (resp *client.Registry, err error) {
+	err = c.retrier.do("registry.byId", true, func() error {
+		resp, err = c.ops.ById(id)
+		return err
+	})
+	return
+}
+
+func (c *retryingRegistryClient) Delete(registry *client.Registry) error {
+	return c.retrier.do("registry.delete", true, func() error {
+		return c.ops.Delete(registry)
+	})
+}
+
+func (c *retryingRegistryClient) action(name string, fn func(*client.Registry) (*client.StoragePool, error), registry *client.Registry) (resp *client.StoragePool, err error) {
+	err = c.retrier.do("registry."+name, false, func() error {
+		resp, err = fn(registry)
+		return err
+	})
+	return
+}
+
+func (c *retryingRegistryClient) ActionActivate(registry *client.Registry) (*client.StoragePool, error) {
+	return c.action("activate", c.ops.ActionActivate, registry)
+}
+
+func (c *retryingRegistryClient) ActionCreate(registry *client.Registry) (*client.StoragePool, error) {
+	return c.action("create", c.ops.ActionCreate, registry)
+}
+
+func (c *retryingRegistryClient) ActionDeactivate(registry *client.Registry) (*client.StoragePool, error) {
+	return c.action("deactivate", c.ops.ActionDeactivate, registry)
+}
+
+func (c *retryingRegistryClient) ActionPurge(registry *client.Registry) (*client.StoragePool, error) {
+	return c.action("purge", c.ops.ActionPurge, registry)
+}
+
+func (c *retryingRegistryClient) ActionRemove(registry *client.Registry) (*client.StoragePool, error) {
+	return c.action("remove", c.ops.ActionRemove, registry)
+}
+
+func (c *retryingRegistryClient) ActionRestore(registry *client.Registry) (*client.StoragePool, error) {
+	return c.action("restore", c.ops.ActionRestore, registry)
+}
+
+func (c *retryingRegistryClient) ActionUpdate(registry *client.Registry) (*client.StoragePool, error) {
+	return c.action("update", c.ops.ActionUpdate, registry)
+}
+
+// retryingRegistryCredentialClient wraps client.RegistryCredentialOperations
+// with retries
+type retryingRegistryCredentialClient struct {
+	ops     client.RegistryCredentialOperations
+	retrier *rancherRetrier
+}
+
+func (c *retryingRegistryCredentialClient) List(opts *client.ListOpts) (resp *client.RegistryCredentialCollection, err error) {
+	err = c.retrier.do("registryCredential.list", true, func() error {
+		resp, err = c.ops.List(opts)
+		return err
+	})
+	return
+}
+
+func (c *retryingRegistryCredentialClient) Create(credential *client.RegistryCredential) (resp *client.RegistryCredential, err error) {
+	err = c.retrier.do("registryCredential.create", false, func() error {
+		resp, err = c.ops.Create(credential)
+		return err
+	})
+	return
+}
+
+func (c *retryingRegistryCredentialClient) Update(existing *client.RegistryCredential, updates interface{}) (resp *client.RegistryCredential, err error) {
+	err = c.retrier.do("registryCredential.update", true, func() error {
+		resp, err = c.ops.Update(existing, updates)
+		return err
+	})
+	return
+}
+
+func (c *retryingRegistryCredentialClient) ById(id string) (resp *client.RegistryCredential, err error) {
+	err = c.retrier.do("registryCredential.byId", true, func() error {
+		resp, err = c.ops.ById(id)
+		return err
+	})
+	return
+}
+
+func (c *retryingRegistryCredentialClient) Delete(credential *client.RegistryCredential) error {
+	return c.retrier.do("registryCredential.delete", true, func() error {
+		return c.ops.Delete(credential)
+	})
+}
+
+func (c *retryingRegistryCredentialClient) action(name string, fn func(*client.RegistryCredential) (*client.Credential, error), credential *client.RegistryCredential) (resp *client.Credential, err error) {
+	err = c.retrier.do("registryCredential."+name, false, func() error {
+		resp, err = fn(credential)
+		return err
+	})
+	return
+}
+
+func (c *retryingRegistryCredentialClient) ActionActivate(credential *client.RegistryCredential) (*client.Credential, error) {
+	return c.action("activate", c.ops.ActionActivate, credential)
+}
+
+func (c *retryingRegistryCredentialClient) ActionCreate(credential *client.RegistryCredential) (*client.Credential, error) {
+	return c.action("create", c.ops.ActionCreate, credential)
+}
+
+func (c *retryingRegistryCredentialClient) ActionDeactivate(credential *client.RegistryCredential) (*client.Credential, error) {
+	return c.action("deactivate", c.ops.ActionDeactivate, credential)
+}
+
+func (c *retryingRegistryCredentialClient) ActionPurge(credential *client.RegistryCredential) (*client.Credential, error) {
+	return c.action("purge", c.ops.ActionPurge, credential)
+}
+
+func (c *retryingRegistryCredentialClient) ActionRemove(credential *client.RegistryCredential) (*client.Credential, error) {
+	return c.action("remove", c.ops.ActionRemove, credential)
+}
+
+func (c *retryingRegistryCredentialClient) ActionUpdate(credential *client.RegistryCredential) (*client.Credential, error) {
+	return c.action("update", c.ops.ActionUpdate, credential)
+}
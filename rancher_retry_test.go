@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRetrier(attempts, threshold int) *rancherRetrier {
+	b := newBackoff(attempts, time.Millisecond)
+	b.sleep = func(time.Duration) {}
+	return newRancherRetrier(context.Background(), b, newCircuitBreaker(threshold, time.Minute))
+}
+
+func TestRancherRetry_retriesIdempotentCalls(t *testing.T) {
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(nil, &client.ApiError{StatusCode: 503}).Twice()
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{}, nil).Once()
+	retries := metrics.Value("ecr_updater_rancher_retries_total", "operation", "registry.list")
+
+	registryClient := &retryingRegistryClient{ops: mockRegistry, retrier: testRetrier(4, 5)}
+	_, err := registryClient.List(&client.ListOpts{})
+
+	assert.NoError(t, err)
+	mockRegistry.AssertExpectations(t)
+	assert.Equal(t, retries+2, metrics.Value("ecr_updater_rancher_retries_total", "operation", "registry.list"))
+}
+
+func TestRancherRetry_createOnlyRetriedWhenThrottled(t *testing.T) {
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	credential := &client.RegistryCredential{RegistryId: "1r1"}
+	mockRegistryCredential.On("Create", credential).Return(nil, &client.ApiError{StatusCode: 429}).Once()
+	mockRegistryCredential.On("Create", credential).Return(nil, &client.ApiError{StatusCode: 500}).Once()
+
+	credentialClient := &retryingRegistryCredentialClient{ops: mockRegistryCredential, retrier: testRetrier(4, 5)}
+	_, err := credentialClient.Create(credential)
+
+	assert.Equal(t, 500, err.(*client.ApiError).StatusCode)
+	mockRegistryCredential.AssertExpectations(t)
+	mockRegistryCredential.AssertNumberOfCalls(t, "Create", 2)
+}
+
+func TestRancherRetry_clientErrorsNotRetried(t *testing.T) {
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistry.On("ById", "1r1").Return(nil, &client.ApiError{StatusCode: 403}).Once()
+
+	registryClient := &retryingRegistryClient{ops: mockRegistry, retrier: testRetrier(4, 5)}
+	_, err := registryClient.ById("1r1")
+
+	assert.Error(t, err)
+	mockRegistry.AssertNumberOfCalls(t, "ById", 1)
+}
+
+func TestRancherRetry_circuitBreaker(t *testing.T) {
+	current := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(nil, errors.New("read: connection reset by peer")).Times(3)
+	registryClient := &retryingRegistryClient{ops: mockRegistry, retrier: testRetrier(3, 3)}
+
+	_, err := registryClient.List(&client.ListOpts{})
+	assert.Error(t, err)
+	mockRegistry.AssertNumberOfCalls(t, "List", 3)
+
+	// the breaker is open, so no further calls reach the server
+	_, err = registryClient.List(&client.ListOpts{})
+	assert.Equal(t, errCircuitOpen, err)
+	mockRegistry.AssertNumberOfCalls(t, "List", 3)
+	assert.Equal(t, float64(circuitOpen), metrics.Value("ecr_updater_rancher_circuit_state"))
+
+	// after the cooldown a trial call is let through and closes the circuit
+	current = current.Add(2 * time.Minute)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{}, nil).Once()
+	_, err = registryClient.List(&client.ListOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(circuitClosed), metrics.Value("ecr_updater_rancher_circuit_state"))
+}
+
+func TestRancherRetry_circuitBreakerHungTrial(t *testing.T) {
+	current := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	breaker := newCircuitBreaker(1, time.Minute)
+	breaker.Record(false)
+	assert.Equal(t, errCircuitOpen, breaker.Allow())
+
+	// the trial call never reports back
+	current = current.Add(time.Minute)
+	assert.NoError(t, breaker.Allow())
+	assert.Equal(t, errCircuitOpen, breaker.Allow())
+
+	// once another cooldown has passed a new trial is let through
+	current = current.Add(time.Minute)
+	assert.NoError(t, breaker.Allow())
+	assert.Equal(t, errCircuitOpen, breaker.Allow())
+	breaker.Record(true)
+	assert.NoError(t, breaker.Allow())
+}
+
+func TestRancherRetry_backoffDelay(t *testing.T) {
+	b := newBackoff(4, time.Second)
+	// the third attempt waits between 2s and 4s
+	delay := b.Delay(3, false)
+	assert.True(t, delay >= 2*time.Second && delay <= 4*time.Second, delay.String())
+	assert.True(t, b.Delay(20, false) <= b.MaxDelay)
+
+	// RANCHER_RETRY_DELAY=0 retries right away rather than after MaxDelay
+	b = newBackoff(4, 0)
+	assert.Equal(t, time.Duration(0), b.Delay(3, true))
+}
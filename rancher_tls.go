@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// rancherTLSConfig holds the TLS and timeout settings of the connection to
+// the Rancher API
+type rancherTLSConfig struct {
+	CABundle   string
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify disables server certificate verification, for lab
+	// setups only
+	InsecureSkipVerify bool
+	// Timeout fails a call once Rancher has sent nothing for this long, while
+	// connecting or waiting for or reading a response
+	Timeout time.Duration
+}
+
+// configured reports whether any setting differs from the defaults
+func (c rancherTLSConfig) configured() bool {
+	return c.CABundle != "" || c.ClientCert != "" || c.ClientKey != "" || c.InsecureSkipVerify || c.Timeout > 0
+}
+
+// tlsConfig returns the TLS settings of the Rancher connection
+func (c rancherTLSConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if c.CABundle != "" {
+		pool, err := loadCABundle(c.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, fmt.Errorf("RANCHER_CLIENT_CERT and RANCHER_CLIENT_KEY must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load Rancher client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if c.InsecureSkipVerify {
+		log.Warn("RANCHER_INSECURE_SKIP_VERIFY is set: the Rancher server certificate is NOT verified and the API keys can be intercepted. Only use this in lab setups.")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// transport returns the transport of the connections to the Rancher server.
+// It is a transport of its own, so its TLS settings and timeouts, including
+// when tunnelled through HTTPS_PROXY, don't touch the connections to other
+// hosts.
+func (c rancherTLSConfig) transport() (*http.Transport, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	dial := dialer.DialContext
+	if c.Timeout > 0 {
+		dialer.Timeout = c.Timeout
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			return &idleTimeoutConn{Conn: conn, Timeout: c.Timeout}, nil
+		}
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: c.Timeout,
+		IdleConnTimeout:       90 * time.Second,
+	}, nil
+}
+
+// idleTimeoutConn fails a read once nothing has arrived for Timeout since the
+// request was written or data last arrived. An http.Client built by the
+// Rancher client has no timeout of its own, so this bounds every stage of a
+// call, including reading the body.
+type idleTimeoutConn struct {
+	net.Conn
+	Timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	return c.Conn.Write(b)
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	}
+	return n, err
+}
+
+// hostTransport sends requests for Host through Transport and every other
+// request through Next. The Rancher client builds its own http.Client on
+// the default transport for each call, so this is how it gets its settings
+// without changing those of other clients.
+type hostTransport struct {
+	Host      string
+	Transport http.RoundTripper
+	Next      http.RoundTripper
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if hostPort(req.URL) == t.Host {
+		return t.Transport.RoundTrip(req)
+	}
+	return t.Next.RoundTrip(req)
+}
+
+// hostPort returns the host of u with the port made explicit
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "443"
+	if u.Scheme == "http" {
+		port = "80"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// installRancherTransport routes the requests to the Rancher server at
+// rancherURL through a transport with the settings
+func installRancherTransport(rancherURL string, c rancherTLSConfig) error {
+	if !c.configured() {
+		return nil
+	}
+	u, err := url.Parse(rancherURL)
+	if err != nil {
+		return fmt.Errorf("Invalid CATTLE_URL: %s", err)
+	}
+	transport, err := c.transport()
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = &hostTransport{Host: hostPort(u), Transport: transport, Next: http.DefaultTransport}
+	return nil
+}
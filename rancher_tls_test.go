@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRancherTLS_caBundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+	dir, err := ioutil.TempDir("", "rancher")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := writeTestFile(t, dir, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})))
+	saved := http.DefaultTransport
+	defer func() { http.DefaultTransport = saved }()
+
+	untrusted, err := rancherTLSConfig{Timeout: time.Second}.transport()
+	assert.NoError(t, err)
+	_, err = (&http.Client{Transport: untrusted}).Get(server.URL)
+	assert.Error(t, err)
+
+	http.DefaultTransport = &http.Transport{}
+	assert.NoError(t, installRancherTransport(server.URL, rancherTLSConfig{CABundle: ca}))
+	resp, err := (&http.Client{}).Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	// other hosts don't get the Rancher settings
+	_, err = (&http.Client{}).Get(other.URL)
+	assert.Error(t, err)
+
+	_, err = rancherTLSConfig{ClientCert: ca}.transport()
+	assert.EqualError(t, err, "RANCHER_CLIENT_CERT and RANCHER_CLIENT_KEY must be set together")
+}
+
+func TestRancherTLS_timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	transport, err := rancherTLSConfig{Timeout: 50 * time.Millisecond}.transport()
+	assert.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if assert.NoError(t, err) {
+		// the body stalls after the first bytes
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Error(t, err)
+	}
+}
+
+func TestRancherTLS_hostPort(t *testing.T) {
+	u, _ := url.Parse("https://rancher.internal/v2-beta")
+	assert.Equal(t, "rancher.internal:443", hostPort(u))
+	u, _ = url.Parse("http://rancher.internal:8080/v2-beta")
+	assert.Equal(t, "rancher.internal:8080", hostPort(u))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_remediations_total", "counter", "Services and containers restarted after their registry credential was refreshed.")
+}
+
+// pullErrors are fragments of the messages Rancher and Docker report when an
+// image cannot be pulled for lack of valid credentials
+var pullErrors = []string{
+	"no basic auth credentials",
+	"unauthorized",
+	"authentication required",
+	"pull access denied",
+	"failed to pull image",
+	"image pull failed",
+}
+
+// serviceLabel is set on containers that belong to a service. They are left
+// to the restart of their service.
+const serviceLabel = "io.rancher.stack_service.name"
+
+// remediator restarts services and containers that failed to pull an image
+// from a registry whose credential was just refreshed. At most MaxActions are
+// taken per cycle.
+type remediator struct {
+	DryRun     bool
+	MaxActions int
+
+	serviceClient   client.ServiceOperations
+	containerClient client.ContainerOperations
+	retrier         *rancherRetrier
+}
+
+// Remediate looks for stuck services and containers on the hosts updated or
+// created in a cycle
+func (m *remediator) Remediate(rep *report) {
+	if m == nil || rep == nil {
+		return
+	}
+	hosts := map[string]bool{}
+	for _, result := range rep.Results {
+		if result.Status == statusUpdated || result.Status == statusCreated {
+			hosts[result.Host] = true
+		}
+	}
+	if len(hosts) == 0 {
+		return
+	}
+
+	actions := 0
+	services, err := listServices(m.serviceClient, &client.ListOpts{})
+	if err != nil {
+		log.Printf("[remediate] Failed to list services: %s\n", err)
+	}
+	for i := range services {
+		service := &services[i]
+		if service.LaunchConfig == nil || !hosts[imageHost(service.LaunchConfig.ImageUuid)] ||
+			!isStuck(service.State, service.Transitioning, service.TransitioningMessage) {
+			continue
+		}
+		if !m.take(&actions) {
+			return
+		}
+		m.restartService(service)
+	}
+
+	containers, err := listContainers(m.containerClient, &client.ListOpts{})
+	if err != nil {
+		log.Printf("[remediate] Failed to list containers: %s\n", err)
+	}
+	for i := range containers {
+		container := &containers[i]
+		if _, ok := container.Labels[serviceLabel]; ok || !hosts[imageHost(container.ImageUuid)] ||
+			!isStuck(container.State, container.Transitioning, container.TransitioningMessage) {
+			continue
+		}
+		if !m.take(&actions) {
+			return
+		}
+		m.startContainer(container)
+	}
+}
+
+// take counts an action against the safety cap, reporting false once it has
+// been reached
+func (m *remediator) take(actions *int) bool {
+	if m.MaxActions > 0 && *actions >= m.MaxActions {
+		log.Warnf("[remediate] Reached the limit of %d actions for this cycle, skipping the rest", m.MaxActions)
+		return false
+	}
+	*actions++
+	return true
+}
+
+func (m *remediator) restartService(service *client.Service) {
+	action := "restart"
+	if service.State == stateInactive {
+		action = "activate"
+	}
+	if m.DryRun {
+		log.Printf("[remediate] Would %s service %s (%s): %s\n", action, service.Name, service.Id, service.TransitioningMessage)
+		metrics.Inc("ecr_updater_remediations_total", "kind", "service", "action", action, "dryRun", "true")
+		return
+	}
+	log.Printf("[remediate] Going to %s service %s (%s): %s\n", action, service.Name, service.Id, service.TransitioningMessage)
+	err := m.retrier.do("service."+action, false, func() error {
+		var err error
+		if action == "activate" {
+			_, err = m.serviceClient.ActionActivate(service)
+		} else {
+			_, err = m.serviceClient.ActionRestart(service, &client.ServiceRestart{
+				RollingRestartStrategy: client.RollingRestartStrategy{BatchSize: 1, IntervalMillis: 2000},
+			})
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("[remediate] Failed to %s service %s: %s\n", action, service.Id, err)
+		return
+	}
+	metrics.Inc("ecr_updater_remediations_total", "kind", "service", "action", action, "dryRun", "false")
+}
+
+func (m *remediator) startContainer(container *client.Container) {
+	if m.DryRun {
+		log.Printf("[remediate] Would start container %s (%s): %s\n", container.Name, container.Id, container.TransitioningMessage)
+		metrics.Inc("ecr_updater_remediations_total", "kind", "container", "action", "start", "dryRun", "true")
+		return
+	}
+	log.Printf("[remediate] Starting container %s (%s): %s\n", container.Name, container.Id, container.TransitioningMessage)
+	err := m.retrier.do("container.start", false, func() error {
+		_, err := m.containerClient.ActionStart(container)
+		return err
+	})
+	if err != nil {
+		log.Printf("[remediate] Failed to start container %s: %s\n", container.Id, err)
+		return
+	}
+	metrics.Inc("ecr_updater_remediations_total", "kind", "container", "action", "start", "dryRun", "false")
+}
+
+// isStuck reports whether a resource is in error, or reports a failure to
+// pull its image
+func isStuck(state, transitioning, message string) bool {
+	if state == "error" || transitioning == transitioningError {
+		return true
+	}
+	message = strings.ToLower(message)
+	for _, fragment := range pullErrors {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageHost returns the registry host of an image such as
+// docker:012345678910.dkr.ecr.us-east-1.amazonaws.com/repo:tag, or "" for
+// images on Docker Hub
+func imageHost(image string) string {
+	image = strings.TrimPrefix(image, "docker:")
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return ""
+	}
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const ecrHost = "012345678910.dkr.ecr.us-east-1.amazonaws.com"
+
+func updatedReport() *report {
+	return &report{Results: []targetResult{{Endpoint: testEndpoint, Host: ecrHost, Status: statusUpdated}}}
+}
+
+func TestRemediate_restartsStuckResources(t *testing.T) {
+	mockServices := new(mocks.ServiceOperations)
+	mockContainers := new(mocks.ContainerOperations)
+	stuck := client.Service{
+		Resource:             client.Resource{Id: "1s1"},
+		State:                stateActive,
+		TransitioningMessage: "Error: image pull failed: no basic auth credentials",
+		LaunchConfig:         &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/app:1"},
+	}
+	mockServices.On("List", &client.ListOpts{}).Return(&client.ServiceCollection{Data: []client.Service{
+		stuck,
+		// healthy, and on another registry
+		{Resource: client.Resource{Id: "1s2"}, State: stateActive, LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/app:1"}},
+		{Resource: client.Resource{Id: "1s3"}, State: "error", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:nginx"}},
+	}}, nil)
+	mockServices.On("ActionRestart", &stuck, mock.AnythingOfType("*client.ServiceRestart")).Return(&client.Service{}, nil)
+	standalone := client.Container{Resource: client.Resource{Id: "1i1"}, State: "error", ImageUuid: "docker:" + ecrHost + "/job:2"}
+	mockContainers.On("List", &client.ListOpts{}).Return(&client.ContainerCollection{Data: []client.Container{
+		standalone,
+		// restarted along with its service
+		{Resource: client.Resource{Id: "1i2"}, State: "error", ImageUuid: "docker:" + ecrHost + "/app:1", Labels: map[string]interface{}{serviceLabel: "stack/app"}},
+	}}, nil)
+	mockContainers.On("ActionStart", &standalone).Return(&client.Instance{}, nil)
+	m := &remediator{MaxActions: 10, serviceClient: mockServices, containerClient: mockContainers, retrier: testRetrier(1, 0)}
+
+	m.Remediate(updatedReport())
+
+	mockServices.AssertExpectations(t)
+	mockContainers.AssertExpectations(t)
+	mockServices.AssertNumberOfCalls(t, "ActionRestart", 1)
+	mockContainers.AssertNumberOfCalls(t, "ActionStart", 1)
+}
+
+func TestRemediate_dryRunAndCap(t *testing.T) {
+	mockServices := new(mocks.ServiceOperations)
+	mockContainers := new(mocks.ContainerOperations)
+	image := &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/app:1"}
+	mockServices.On("List", &client.ListOpts{}).Return(&client.ServiceCollection{Data: []client.Service{
+		{Resource: client.Resource{Id: "1s1"}, State: "error", LaunchConfig: image},
+		{Resource: client.Resource{Id: "1s2"}, State: "error", LaunchConfig: image},
+	}}, nil)
+	m := &remediator{DryRun: true, MaxActions: 1, serviceClient: mockServices, containerClient: mockContainers}
+	before := metrics.Value("ecr_updater_remediations_total", "kind", "service", "action", "restart", "dryRun", "true")
+
+	m.Remediate(updatedReport())
+
+	mockServices.AssertNotCalled(t, "ActionRestart", mock.Anything, mock.Anything)
+	// the cap stops the cycle before containers are listed
+	mockContainers.AssertNotCalled(t, "List", mock.Anything)
+	assert.Equal(t, before+1, metrics.Value("ecr_updater_remediations_total", "kind", "service", "action", "restart", "dryRun", "true"))
+}
+
+func TestRemediate_imageHost(t *testing.T) {
+	assert.Equal(t, ecrHost, imageHost("docker:"+ecrHost+"/team/app:1"))
+	assert.Equal(t, "localhost:5000", imageHost("localhost:5000/app"))
+	assert.Equal(t, "", imageHost("docker:library/nginx:latest"))
+	assert.Equal(t, "", imageHost("docker:nginx"))
+}
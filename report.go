@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Outcomes of processing a single authorization token
+const (
+	statusUpdated   = "updated"
+	statusUnchanged = "unchanged"
+	statusCreated   = "created"
+	statusMissing   = "missing"
+	statusFailed    = "failed"
+	statusTimeout   = "timeout"
+	statusCancelled = "cancelled"
+)
+
+// targetResult is the outcome of syncing one ECR authorization token into Rancher
+type targetResult struct {
+	Endpoint     string `json:"endpoint"`
+	Host         string `json:"host,omitempty"`
+	RegistryID   string `json:"registryId,omitempty"`
+	CredentialID string `json:"credentialId,omitempty"`
+	// State is the credential's Rancher state once the change settled
+	State string `json:"state,omitempty"`
+	// ExpiresAt is when the synced token expires
+	ExpiresAt *time.Time    `json:"expiresAt,omitempty"`
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+func (t targetResult) failed(err error) targetResult {
+	t.Status = statusFailed
+	t.Error = err.Error()
+	return t
+}
+
+func (t targetResult) cancelled(err error) targetResult {
+	t.Status = statusCancelled
+	t.Error = err.Error()
+	return t
+}
+
+// report collects the results of a single reconcile cycle
+type report struct {
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Error    string         `json:"error,omitempty"`
+	Results  []targetResult `json:"results"`
+	// ProviderFailures lists the registries no token could be retrieved for
+	ProviderFailures []providerFailure `json:"providerFailures,omitempty"`
+}
+
+// finish stamps the report, logs a summary, publishes it on /status and
+// returns it
+func (rep *report) finish(err error) *report {
+	rep.Finished = now()
+	if err != nil {
+		rep.Error = err.Error()
+	}
+	counts := map[string]int{}
+	for _, result := range rep.Results {
+		counts[result.Status]++
+	}
+	log.WithFields(log.Fields{
+		statusUpdated:      counts[statusUpdated],
+		statusUnchanged:    counts[statusUnchanged],
+		statusCreated:      counts[statusCreated],
+		statusMissing:      counts[statusMissing],
+		statusFailed:       counts[statusFailed],
+		statusTimeout:      counts[statusTimeout],
+		statusCancelled:    counts[statusCancelled],
+		"providerFailures": len(rep.ProviderFailures),
+	}).Infof("Finished updating ECR Credentials in %s", rep.Finished.Sub(rep.Started))
+	status.Set(rep)
+	return rep
+}
+
+// reconcile runs process for each authorization token on a pool of at most
+// Concurrency workers. Each token is given TargetTimeout to complete; a token
+// that runs over is reported as timed out and its worker moves on while the
+// call finishes in the background. Tokens not yet started when ctx is done
+// are reported as cancelled.
+func (r *Rancher) reconcile(ctx context.Context, authData []registryAuth, process func(context.Context, registryAuth) targetResult) []targetResult {
+	workers := r.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(authData) {
+		workers = len(authData)
+	}
+
+	results := make([]targetResult, len(authData))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = r.processWithTimeout(ctx, authData[i], process)
+			}
+		}()
+	}
+dispatch:
+	for i := range authData {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			for ; i < len(authData); i++ {
+				results[i] = targetResult{
+					Endpoint: authData[i].Endpoint,
+					Status:   statusCancelled,
+					Error:    ctx.Err().Error(),
+				}
+			}
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func (r *Rancher) processWithTimeout(ctx context.Context, data registryAuth, process func(context.Context, registryAuth) targetResult) targetResult {
+	start := time.Now()
+	// Only the timeout abandons a token. When the parent context is cancelled
+	// process stops before its next change to Rancher and is waited for, so
+	// calls already in flight complete during shutdown.
+	var timeout <-chan time.Time
+	if r.TargetTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.TargetTimeout)
+		defer cancel()
+		timer := time.NewTimer(r.TargetTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	done := make(chan targetResult, 1)
+	go func() {
+		done <- process(ctx, data)
+	}()
+
+	var result targetResult
+	select {
+	case result = <-done:
+	case <-timeout:
+		log.Printf("[%s] Timed out after %s\n", data.Endpoint, r.TargetTimeout)
+		result = targetResult{
+			Endpoint: data.Endpoint,
+			Status:   statusTimeout,
+			Error:    fmt.Sprintf("Timed out after %s", r.TargetTimeout),
+		}
+	}
+	result.Duration = time.Since(start)
+	return result
+}
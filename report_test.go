@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport_reconcileTimeout(t *testing.T) {
+	r := &Rancher{Concurrency: 2, TargetTimeout: 50 * time.Millisecond}
+	authData := []registryAuth{
+		{Endpoint: "https://slow"},
+		{Endpoint: "https://fast-1"},
+		{Endpoint: "https://fast-2"},
+	}
+	release := make(chan struct{})
+	defer close(release)
+
+	results := r.reconcile(context.Background(), authData, func(ctx context.Context, data registryAuth) targetResult {
+		if data.Endpoint == "https://slow" {
+			<-release
+		}
+		return targetResult{Endpoint: data.Endpoint, Status: statusUpdated}
+	})
+
+	assert.Len(t, results, 3)
+	assert.Equal(t, statusTimeout, results[0].Status)
+	assert.Equal(t, "https://slow", results[0].Endpoint)
+	assert.Equal(t, statusUpdated, results[1].Status)
+	assert.Equal(t, statusUpdated, results[2].Status)
+}
+
+func TestReport_reconcileConcurrency(t *testing.T) {
+	r := &Rancher{Concurrency: 2}
+	authData := make([]registryAuth, 6)
+	for i := range authData {
+		authData[i] = registryAuth{Endpoint: "https://registry"}
+	}
+	var running, peak int32
+
+	results := r.reconcile(context.Background(), authData, func(ctx context.Context, data registryAuth) targetResult {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			seen := atomic.LoadInt32(&peak)
+			if current <= seen || atomic.CompareAndSwapInt32(&peak, seen, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return targetResult{Endpoint: data.Endpoint, Status: statusUpdated}
+	})
+
+	assert.Len(t, results, 6)
+	assert.True(t, peak <= 2, "at most two tokens should be processed at once")
+}
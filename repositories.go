@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/rancher/go-rancher/client"
+)
+
+func init() {
+	metrics.Describe("ecr_updater_repositories_created_total", "counter", "ECR repositories created because a service referenced them.")
+}
+
+// defaultRepositoryPolicy is applied to created repositories unless
+// REPOSITORY_POLICY_FILE names another. It grants the repository's own account
+// pull access, a starting point for policies that add other accounts.
+const defaultRepositoryPolicy = `{
+  "Version": "2008-10-17",
+  "Statement": [
+    {
+      "Sid": "RancherEcrCredentialsDefault",
+      "Effect": "Allow",
+      "Principal": {"AWS": "arn:aws:iam::{account}:root"},
+      "Action": [
+        "ecr:BatchCheckLayerAvailability",
+        "ecr:BatchGetImage",
+        "ecr:GetDownloadUrlForLayer"
+      ]
+    }
+  ]
+}`
+
+// ecrImage is a reference to an image in ECR
+type ecrImage struct {
+	Info       ecrHostInfo
+	Repository string
+	// Tag is empty when the image is referenced by Digest
+	Tag    string
+	Digest string
+}
+
+// parseEcrImage splits an image such as
+// docker:012345678910.dkr.ecr.us-east-1.amazonaws.com/team/app:1 into its
+// parts. It reports false for images that are not in ECR.
+func parseEcrImage(image string) (ecrImage, bool) {
+	info := parseEcrHost(imageHost(image))
+	if info.Account == "" {
+		return ecrImage{}, false
+	}
+	image = strings.TrimPrefix(image, "docker:")
+	ref := ecrImage{Info: info, Repository: image[strings.Index(image, "/")+1:]}
+	if i := strings.Index(ref.Repository, "@"); i >= 0 {
+		ref.Repository, ref.Digest = ref.Repository[:i], ref.Repository[i+1:]
+		return ref, true
+	}
+	ref.Tag = "latest"
+	if i := strings.LastIndex(ref.Repository, ":"); i >= 0 {
+		ref.Repository, ref.Tag = ref.Repository[:i], ref.Repository[i+1:]
+	}
+	return ref, true
+}
+
+// repositoryCreator creates the ECR repositories that services reference but
+// that do not exist yet. CreateRepository always creates the repository in
+// the account of the AWS credentials, so only Accounts are considered.
+type repositoryCreator struct {
+	Accounts []string
+	// Policy, if set, is applied to created repositories. The {account},
+	// {region} and {repository} placeholders are replaced.
+	Policy string
+	DryRun bool
+
+	serviceClient client.ServiceOperations
+	ecrClient     func(account, region string) ecriface.ECRAPI
+}
+
+// Reconcile creates the missing repositories
+func (c *repositoryCreator) Reconcile(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	images, err := serviceImages(c.serviceClient)
+	if err != nil {
+		log.Printf("[repositories] %s\n", err)
+		return
+	}
+	wanted := map[ecrHostInfo]map[string]bool{}
+	for _, image := range images {
+		ref, ok := parseEcrImage(image)
+		if !ok || !c.allowed(ref.Info.Account) {
+			continue
+		}
+		if wanted[ref.Info] == nil {
+			wanted[ref.Info] = map[string]bool{}
+		}
+		wanted[ref.Info][ref.Repository] = true
+	}
+
+	for info, repositories := range wanted {
+		if ctx.Err() != nil {
+			return
+		}
+		svc := c.ecrClient(info.Account, info.Region)
+		existing, err := describeRepositories(svc, info.Account)
+		if err != nil {
+			newAwsCallFailure("DescribeRepositories", info.Account, err)
+			continue
+		}
+		var missing []string
+		for name := range repositories {
+			if !existing[name] {
+				missing = append(missing, name)
+			}
+		}
+		sort.Strings(missing)
+		for _, name := range missing {
+			c.create(svc, info, name)
+		}
+	}
+}
+
+// checkRepositoryAccounts rejects accounts other than the one of the AWS
+// credentials, since CreateRepository can't create repositories anywhere else
+func checkRepositoryAccounts(accounts []string, callerAccount string) error {
+	var others []string
+	for _, account := range accounts {
+		if account != callerAccount {
+			others = append(others, account)
+		}
+	}
+	if len(others) > 0 {
+		return fmt.Errorf("Repositories can only be created in account %s of the AWS credentials, not in %s",
+			callerAccount, strings.Join(others, ", "))
+	}
+	return nil
+}
+
+func (c *repositoryCreator) allowed(account string) bool {
+	for _, a := range c.Accounts {
+		if a == account {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *repositoryCreator) create(svc ecriface.ECRAPI, info ecrHostInfo, name string) {
+	if c.DryRun {
+		log.Printf("[repositories] Would create repository %s in %s/%s\n", name, info.Account, info.Region)
+		return
+	}
+	log.Printf("[repositories] Creating repository %s in %s/%s\n", name, info.Account, info.Region)
+	resp, err := svc.CreateRepository(&ecr.CreateRepositoryInput{RepositoryName: aws.String(name)})
+	if err != nil {
+		newAwsCallFailure("CreateRepository", info.Account, err)
+		return
+	}
+	metrics.Inc("ecr_updater_repositories_created_total")
+	if resp.Repository != nil && aws.StringValue(resp.Repository.RegistryId) != info.Account {
+		log.Warnf("[repositories] Repository %s was created in account %s, not %s; check CREATE_REPOSITORIES_ACCOUNTS",
+			name, aws.StringValue(resp.Repository.RegistryId), info.Account)
+		return
+	}
+	if c.Policy == "" {
+		return
+	}
+	policy := strings.NewReplacer("{account}", info.Account, "{region}", info.Region, "{repository}", name).Replace(c.Policy)
+	_, err = svc.SetRepositoryPolicy(&ecr.SetRepositoryPolicyInput{
+		RegistryId:     aws.String(info.Account),
+		RepositoryName: aws.String(name),
+		PolicyText:     aws.String(policy),
+	})
+	if err != nil {
+		newAwsCallFailure("SetRepositoryPolicy", info.Account, err)
+	}
+}
+
+// describeRepositories returns the names of every repository in a registry.
+// An empty registryID is the registry of the AWS credentials.
+func describeRepositories(svc ecriface.ECRAPI, registryID string) (map[string]bool, error) {
+	names := map[string]bool{}
+	input := &ecr.DescribeRepositoriesInput{}
+	if registryID != "" {
+		input.RegistryId = aws.String(registryID)
+	}
+	for page := 0; page < maxListPages; page++ {
+		resp, err := svc.DescribeRepositories(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, repository := range resp.Repositories {
+			names[aws.StringValue(repository.RepositoryName)] = true
+		}
+		if aws.StringValue(resp.NextToken) == "" {
+			return names, nil
+		}
+		input = &ecr.DescribeRepositoriesInput{RegistryId: input.RegistryId, NextToken: resp.NextToken}
+	}
+	return nil, fmt.Errorf("Repository listing exceeded %d pages", maxListPages)
+}
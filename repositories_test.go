@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRepositories_parseEcrImage(t *testing.T) {
+	ref, ok := parseEcrImage("docker:" + ecrHost + "/team/app:1.2")
+	assert.True(t, ok)
+	assert.Equal(t, ecrImage{Info: ecrHostInfo{Account: "012345678910", Region: "us-east-1"}, Repository: "team/app", Tag: "1.2"}, ref)
+
+	ref, _ = parseEcrImage(ecrHost + "/app")
+	assert.Equal(t, "latest", ref.Tag)
+
+	ref, _ = parseEcrImage(ecrHost + "/app@sha256:abc")
+	assert.Equal(t, "app", ref.Repository)
+	assert.Equal(t, "sha256:abc", ref.Digest)
+	assert.Equal(t, "", ref.Tag)
+
+	_, ok = parseEcrImage("docker:nginx:latest")
+	assert.False(t, ok)
+}
+
+func TestRepositories_createsMissing(t *testing.T) {
+	mockServices := new(mocks.ServiceOperations)
+	mockServices.On("List", &client.ListOpts{}).Return(&client.ServiceCollection{Data: []client.Service{
+		{LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/existing:1"}},
+		{LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/team/new:1"}},
+		// not an account the updater may create repositories in
+		{LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:109876543210.dkr.ecr.us-east-1.amazonaws.com/other:1"}},
+	}}, nil)
+	mockEcr := new(mocks.ECRAPI)
+	mockEcr.On("DescribeRepositories", &ecr.DescribeRepositoriesInput{RegistryId: aws.String("012345678910")}).Return(
+		&ecr.DescribeRepositoriesOutput{
+			Repositories: []*ecr.Repository{{RepositoryName: aws.String("existing")}},
+			NextToken:    aws.String("page-2"),
+		}, nil).Once()
+	mockEcr.On("DescribeRepositories", &ecr.DescribeRepositoriesInput{RegistryId: aws.String("012345678910"), NextToken: aws.String("page-2")}).Return(
+		&ecr.DescribeRepositoriesOutput{}, nil).Once()
+	mockEcr.On("CreateRepository", &ecr.CreateRepositoryInput{RepositoryName: aws.String("team/new")}).Return(
+		&ecr.CreateRepositoryOutput{Repository: &ecr.Repository{RegistryId: aws.String("012345678910")}}, nil)
+	mockEcr.On("SetRepositoryPolicy", &ecr.SetRepositoryPolicyInput{
+		RegistryId:     aws.String("012345678910"),
+		RepositoryName: aws.String("team/new"),
+		PolicyText:     aws.String(`{"Sid": "team/new-in-us-east-1"}`),
+	}).Return(&ecr.SetRepositoryPolicyOutput{}, nil)
+	var regions []string
+	c := &repositoryCreator{
+		Accounts:      []string{"012345678910"},
+		Policy:        `{"Sid": "{repository}-in-{region}"}`,
+		serviceClient: mockServices,
+		ecrClient: func(account, region string) ecriface.ECRAPI {
+			regions = append(regions, region)
+			return mockEcr
+		},
+	}
+
+	c.Reconcile(context.Background())
+
+	mockEcr.AssertExpectations(t)
+	assert.Equal(t, []string{"us-east-1"}, regions)
+}
+
+func TestRepositories_dryRun(t *testing.T) {
+	mockServices := new(mocks.ServiceOperations)
+	mockServices.On("List", &client.ListOpts{}).Return(&client.ServiceCollection{Data: []client.Service{
+		{LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/new:1"}},
+	}}, nil)
+	mockEcr := new(mocks.ECRAPI)
+	mockEcr.On("DescribeRepositories", mock.Anything).Return(&ecr.DescribeRepositoriesOutput{}, nil)
+	c := &repositoryCreator{
+		Accounts:      []string{"012345678910"},
+		DryRun:        true,
+		serviceClient: mockServices,
+		ecrClient:     func(string, string) ecriface.ECRAPI { return mockEcr },
+	}
+
+	c.Reconcile(context.Background())
+
+	mockEcr.AssertNotCalled(t, "CreateRepository", mock.Anything)
+}
+
+func TestRepositories_checkAccounts(t *testing.T) {
+	assert.NoError(t, checkRepositoryAccounts([]string{"012345678910"}, "012345678910"))
+
+	err := checkRepositoryAccounts([]string{"012345678910", "109876543210"}, "012345678910")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "not in 109876543210")
+	}
+}
+
+func TestRepositories_defaultPolicy(t *testing.T) {
+	policy := strings.NewReplacer("{account}", "012345678910").Replace(defaultRepositoryPolicy)
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(policy), &doc))
+	assert.Contains(t, policy, "arn:aws:iam::012345678910:root")
+}
+
+func TestRepositories_countsCallErrors(t *testing.T) {
+	mockServices := new(mocks.ServiceOperations)
+	mockServices.On("List", &client.ListOpts{}).Return(&client.ServiceCollection{Data: []client.Service{
+		{LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:" + ecrHost + "/new:1"}},
+	}}, nil)
+	mockEcr := new(mocks.ECRAPI)
+	mockEcr.On("DescribeRepositories", mock.Anything).Return(&ecr.DescribeRepositoriesOutput{}, nil)
+	mockEcr.On("CreateRepository", mock.Anything).Return(nil, awserr.New("AccessDeniedException", "denied", nil))
+	c := &repositoryCreator{
+		Accounts:      []string{"012345678910"},
+		serviceClient: mockServices,
+		ecrClient:     func(string, string) ecriface.ECRAPI { return mockEcr },
+	}
+	before := metrics.Value("ecr_updater_aws_call_errors_total", "operation", "CreateRepository", "class", errClassAccessDenied)
+	tokenBefore := metrics.Value("ecr_updater_aws_errors_total", "class", errClassAccessDenied)
+
+	c.Reconcile(context.Background())
+
+	assert.Equal(t, before+1, metrics.Value("ecr_updater_aws_call_errors_total", "operation", "CreateRepository", "class", errClassAccessDenied))
+	assert.Equal(t, tokenBefore, metrics.Value("ecr_updater_aws_errors_total", "class", errClassAccessDenied))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned without making a call while the circuit breaker
+// is open
+var errCircuitOpen = errors.New("Circuit breaker is open, not calling the Rancher API")
+
+// backoff computes exponentially growing, jittered delays between retries
+type backoff struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// sleep replaces the timer in tests
+	sleep func(time.Duration)
+}
+
+func newBackoff(attempts int, baseDelay time.Duration) *backoff {
+	return &backoff{
+		Attempts:  attempts,
+		BaseDelay: baseDelay,
+		MaxDelay:  30 * time.Second,
+	}
+}
+
+// Delay returns how long to wait after the given failed attempt (starting at
+// 1). Throttled attempts back off twice as long. A BaseDelay of zero or less
+// retries without waiting.
+func (b *backoff) Delay(attempt int, throttled bool) time.Duration {
+	if b.BaseDelay <= 0 {
+		return 0
+	}
+	delay := b.BaseDelay << uint(attempt-1)
+	if throttled {
+		delay *= 2
+	}
+	// a shift past the range of Duration overflows to zero or less
+	if delay > b.MaxDelay || delay <= 0 {
+		delay = b.MaxDelay
+	}
+	// keep between 50% and 100% of the delay so callers don't retry in lockstep
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Wait sleeps for the delay following the given attempt. It returns the
+// context's error, without sleeping, once the context is done.
+func (b *backoff) Wait(ctx context.Context, attempt int, throttled bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if b.sleep != nil {
+		b.sleep(b.Delay(attempt, throttled))
+		return ctx.Err()
+	}
+	timer := time.NewTimer(b.Delay(attempt, throttled))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Circuit breaker states
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops calls to a server after Threshold consecutive failures.
+// Once Cooldown has passed a single trial call is let through; its success
+// closes the circuit again. A trial that has not reported back within another
+// Cooldown is given up on and the next call becomes the trial.
+type circuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	// onChange is called with the new state whenever it changes
+	onChange func(state int)
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	trialAt  time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow returns errCircuitOpen if a call should not be made right now
+func (c *circuitBreaker) Allow() error {
+	if c == nil || c.Threshold < 1 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case circuitOpen:
+		if now().Sub(c.openedAt) < c.Cooldown {
+			return errCircuitOpen
+		}
+		c.trialAt = now()
+		c.setState(circuitHalfOpen)
+		return nil
+	case circuitHalfOpen:
+		// a trial call is already in flight, unless it has hung
+		if now().Sub(c.trialAt) < c.Cooldown {
+			return errCircuitOpen
+		}
+		c.trialAt = now()
+		return nil
+	}
+	return nil
+}
+
+// Record updates the breaker with the outcome of a call. healthy is false
+// for failures that indicate the server is unavailable.
+func (c *circuitBreaker) Record(healthy bool) {
+	if c == nil || c.Threshold < 1 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if healthy {
+		c.failures = 0
+		c.setState(circuitClosed)
+		return
+	}
+	c.failures++
+	if c.state == circuitHalfOpen || c.failures >= c.Threshold {
+		c.openedAt = now()
+		c.setState(circuitOpen)
+	}
+}
+
+func (c *circuitBreaker) setState(state int) {
+	if c.state == state {
+		return
+	}
+	c.state = state
+	if c.onChange != nil {
+		c.onChange(state)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// expiryMargin is how long before a token expires it is refreshed, even if the
+// regular interval has not passed yet
+const expiryMargin = 30 * time.Minute
+
+// targetState is what the updater remembers about a token it synced. The
+// fingerprint is a hash of the token; the token itself is never stored.
+type targetState struct {
+	Fingerprint  string    `json:"fingerprint"`
+	CredentialID string    `json:"credentialId,omitempty"`
+	ExpiresAt    time.Time `json:"expiresAt,omitempty"`
+	LastSuccess  time.Time `json:"lastSuccess"`
+}
+
+// stateStore persists targetState per ECR endpoint in a JSON file so
+// unchanged tokens are not rewritten and the schedule survives restarts. A nil
+// store remembers nothing.
+type stateStore struct {
+	Path string
+
+	mu      sync.Mutex
+	targets map[string]targetState
+}
+
+// loadState reads the state file at path. A missing file gives an empty store.
+func loadState(path string) (*stateStore, error) {
+	s := &stateStore{Path: path, targets: map[string]targetState{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.targets); err != nil {
+		return nil, fmt.Errorf("Unable to parse state file %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// Get returns the state recorded for an endpoint
+func (s *stateStore) Get(endpoint string) (targetState, bool) {
+	if s == nil {
+		return targetState{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.targets[endpoint]
+	return t, ok
+}
+
+// Record stores the state of an endpoint that was synced successfully
+func (s *stateStore) Record(endpoint string, t targetState) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets[endpoint] = t
+}
+
+// Targets returns a copy of the state of every endpoint
+func (s *stateStore) Targets() map[string]targetState {
+	targets := map[string]targetState{}
+	if s == nil {
+		return targets
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for endpoint, t := range s.targets {
+		targets[endpoint] = t
+	}
+	return targets
+}
+
+// Save writes the store to its file
+func (s *stateStore) Save() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	b, err := json.MarshalIndent(s.targets, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, b)
+}
+
+// NextDue returns when the next refresh is due: interval after the oldest
+// success, or shortly before the first token expires. It returns the zero
+// time if nothing has been recorded.
+func (s *stateStore) NextDue(interval time.Duration) time.Time {
+	var due time.Time
+	if s == nil {
+		return due
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.targets {
+		next := t.LastSuccess.Add(interval)
+		if !t.ExpiresAt.IsZero() && t.ExpiresAt.Add(-expiryMargin).Before(next) {
+			next = t.ExpiresAt.Add(-expiryMargin)
+		}
+		if due.IsZero() || next.Before(due) {
+			due = next
+		}
+	}
+	return due
+}
+
+// fingerprint identifies a token without revealing it
+func fingerprint(username, password string) string {
+	sum := sha256.Sum256([]byte(username + ":" + password))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic writes b to a temporary file and renames it into place so
+// readers never see a partial write
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const testEndpoint = "https://012345678910.dkr.ecr.us-east-1.amazonaws.com"
+
+func TestState_saveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	s, err := loadState(path)
+	assert.NoError(t, err)
+	_, ok := s.Get(testEndpoint)
+	assert.False(t, ok)
+
+	recorded := targetState{
+		Fingerprint:  fingerprint("mockUser", "mockPassword"),
+		CredentialID: "1rc1",
+		ExpiresAt:    time.Date(2017, 7, 2, 0, 0, 0, 0, time.UTC),
+		LastSuccess:  time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC),
+	}
+	s.Record(testEndpoint, recorded)
+	assert.NoError(t, s.Save())
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(b), "mockPassword"), "the token must not be stored")
+
+	loaded, err := loadState(path)
+	assert.NoError(t, err)
+	got, ok := loaded.Get(testEndpoint)
+	assert.True(t, ok)
+	assert.Equal(t, recorded, got)
+}
+
+func TestState_nextDue(t *testing.T) {
+	success := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	s := &stateStore{targets: map[string]targetState{}}
+	assert.True(t, s.NextDue(6*time.Hour).IsZero())
+
+	s.Record("a", targetState{LastSuccess: success, ExpiresAt: success.Add(12 * time.Hour)})
+	assert.Equal(t, success.Add(6*time.Hour), s.NextDue(6*time.Hour))
+
+	// a token expiring before the interval passes is refreshed ahead of it
+	s.Record("b", targetState{LastSuccess: success, ExpiresAt: success.Add(2 * time.Hour)})
+	assert.Equal(t, success.Add(2*time.Hour-expiryMargin), s.NextDue(6*time.Hour))
+
+	var disabled *stateStore
+	assert.True(t, disabled.NextDue(6*time.Hour).IsZero())
+}
+
+func TestState_skipsUnchangedToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	r := &Rancher{state: &stateStore{Path: filepath.Join(dir, "state.json"), targets: map[string]targetState{}}}
+	r.state.Record(testEndpoint, targetState{
+		Fingerprint:  fingerprint("mockUser", "mockPassword"),
+		CredentialID: "1rc1",
+	})
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{
+		Data: []client.Registry{{Resource: client.Resource{Id: "1r1"}, ServerAddress: "012345678910.dkr.ecr.us-east-1.amazonaws.com"}},
+	}, nil)
+	mockRegistryCredential.On("List", mock.Anything).Return(&client.RegistryCredentialCollection{
+		Data: []client.RegistryCredential{{Resource: client.Resource{Id: "1rc1"}, RegistryId: "1r1", State: stateActive}},
+	}, nil)
+
+	rep := r.updateEcr(context.Background(), mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	mockRegistryCredential.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+	assert.Equal(t, statusUnchanged, rep.Results[0].Status)
+	got, _ := r.state.Get(testEndpoint)
+	assert.False(t, got.LastSuccess.IsZero())
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// statusHolder serves the report of the most recent update cycle as JSON
+type statusHolder struct {
+	mu   sync.Mutex
+	last *report
+}
+
+// status is the holder served on /status
+var status = &statusHolder{}
+
+// Set records the report of a finished cycle
+func (s *statusHolder) Set(rep *report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = rep
+}
+
+// Last returns the report of the most recent cycle, or nil before the first
+// one has finished
+func (s *statusHolder) Last() *report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+func (s *statusHolder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	last := s.Last()
+	if last == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if waiting, reason := rancherConnection.Waiting(); waiting {
+			json.NewEncoder(w).Encode(map[string]string{"status": "waiting for Rancher", "error": reason})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "pending"})
+		return
+	}
+	json.NewEncoder(w).Encode(last)
+}
+
+// jsonHolder serves the latest result of a job that runs on its own schedule
+// as JSON
+type jsonHolder struct {
+	mu   sync.Mutex
+	last interface{}
+}
+
+// Set records the result of a finished run
+func (h *jsonHolder) Set(v interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = v
+}
+
+// Last returns the most recent result, or nil before the first run has
+// finished
+func (h *jsonHolder) Last() interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+func (h *jsonHolder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	last := h.Last()
+	if last == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "pending"})
+		return
+	}
+	json.NewEncoder(w).Encode(last)
+}
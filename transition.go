@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/client"
+)
+
+// Rancher resource states and transitioning values
+const (
+	stateActive              = "active"
+	stateInactive            = "inactive"
+	transitioningNo          = "no"
+	transitioningError       = "error"
+	defaultTransitionTimeout = 2 * time.Minute
+	defaultTransitionPoll    = 2 * time.Second
+)
+
+// resourceState is the part of a Rancher resource that describes its state
+type resourceState struct {
+	State                string
+	Transitioning        string
+	TransitioningMessage string
+}
+
+// waitForState polls a resource until it reaches want, ends up in an error
+// transition, settles in another state, or TransitionTimeout passes. It
+// returns the last state seen. A zero TransitionTimeout disables waiting.
+func (r *Rancher) waitForState(ctx context.Context, want string, current resourceState, poll func() (resourceState, error)) (resourceState, error) {
+	if r.TransitionTimeout <= 0 {
+		return current, nil
+	}
+	interval := r.TransitionPollInterval
+	if interval <= 0 {
+		interval = defaultTransitionPoll
+	}
+	timeout := time.NewTimer(r.TransitionTimeout)
+	defer timeout.Stop()
+	for {
+		if current.State == want {
+			return current, nil
+		}
+		if current.Transitioning == transitioningError {
+			return current, fmt.Errorf("Transition failed in state %s: %s", current.State, current.TransitioningMessage)
+		}
+		if current.Transitioning == transitioningNo {
+			return current, fmt.Errorf("Settled in state %s instead of %s", current.State, want)
+		}
+		select {
+		case <-ctx.Done():
+			return current, ctx.Err()
+		case <-timeout.C:
+			return current, fmt.Errorf("Timed out after %s waiting for state %s, last state %s", r.TransitionTimeout, want, current.State)
+		case <-time.After(interval):
+		}
+		next, err := poll()
+		if err != nil {
+			return current, err
+		}
+		current = next
+	}
+}
+
+// settleCredential waits for a credential that was just created or updated to
+// become active. A credential that ends up inactive is activated once. It
+// returns the last copy of the credential seen.
+func (r *Rancher) settleCredential(
+	ctx context.Context,
+	endpoint string,
+	credential *client.RegistryCredential,
+	registryCredentialClient client.RegistryCredentialOperations) (*client.RegistryCredential, error) {
+
+	last := credential
+	poll := func() (resourceState, error) {
+		current, err := registryCredentialClient.ById(credential.Id)
+		if err != nil {
+			return resourceState{}, err
+		}
+		if current == nil {
+			return resourceState{}, fmt.Errorf("Registry credential %s was removed", credential.Id)
+		}
+		last = current
+		return credentialState(current), nil
+	}
+
+	state, err := r.waitForState(ctx, stateActive, credentialState(credential), poll)
+	if state.State != stateInactive || ctx.Err() != nil {
+		return last, err
+	}
+	log.Printf("[%s] Registry credential %s is inactive, activating it\n", endpoint, credential.Id)
+	if _, err := registryCredentialClient.ActionActivate(last); err != nil {
+		return last, fmt.Errorf("Unable to activate registry credential %s: %s", credential.Id, err)
+	}
+	// the action's response is a plain credential, so poll for the state
+	state, err = poll()
+	if err != nil {
+		return last, err
+	}
+	_, err = r.waitForState(ctx, stateActive, state, poll)
+	return last, err
+}
+
+func credentialState(credential *client.RegistryCredential) resourceState {
+	return resourceState{
+		State:                credential.State,
+		Transitioning:        credential.Transitioning,
+		TransitioningMessage: credential.TransitioningMessage,
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/rancher-ecr-credentials/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// mockUpdatedCredential sets up a registry with one credential whose Update
+// returns updated
+func mockUpdatedCredential(updated *client.RegistryCredential) (*mocks.RegistryOperations, *mocks.RegistryCredentialOperations) {
+	mockRegistry := new(mocks.RegistryOperations)
+	mockRegistryCredential := new(mocks.RegistryCredentialOperations)
+	mockRegistry.On("List", &client.ListOpts{}).Return(&client.RegistryCollection{
+		Data: []client.Registry{{Resource: client.Resource{Id: "1r1"}, ServerAddress: "012345678910.dkr.ecr.us-east-1.amazonaws.com"}},
+	}, nil)
+	mockRegistryCredential.On("List", mock.Anything).Return(&client.RegistryCredentialCollection{
+		Data: []client.RegistryCredential{{Resource: client.Resource{Id: "1rc1"}, RegistryId: "1r1"}},
+	}, nil)
+	mockRegistryCredential.On("Update", mock.Anything, mock.Anything).Return(updated, nil)
+	return mockRegistry, mockRegistryCredential
+}
+
+func TestTransition_waitsForActive(t *testing.T) {
+	r := &Rancher{TransitionTimeout: time.Second, TransitionPollInterval: time.Millisecond}
+	mockRegistry, mockRegistryCredential := mockUpdatedCredential(
+		&client.RegistryCredential{Resource: client.Resource{Id: "1rc1"}, State: "updating-active", Transitioning: "yes"})
+	mockRegistryCredential.On("ById", "1rc1").Return(
+		&client.RegistryCredential{Resource: client.Resource{Id: "1rc1"}, State: stateActive, Transitioning: "no"}, nil).Once()
+
+	rep := r.updateEcr(context.Background(), mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	mockRegistryCredential.AssertExpectations(t)
+	assert.Equal(t, statusUpdated, rep.Results[0].Status)
+	assert.Equal(t, stateActive, rep.Results[0].State)
+}
+
+func TestTransition_errorFails(t *testing.T) {
+	r := &Rancher{TransitionTimeout: time.Second, TransitionPollInterval: time.Millisecond}
+	mockRegistry, mockRegistryCredential := mockUpdatedCredential(
+		&client.RegistryCredential{Resource: client.Resource{Id: "1rc1"}, State: "updating-active", Transitioning: "yes"})
+	mockRegistryCredential.On("ById", "1rc1").Return(&client.RegistryCredential{
+		Resource:             client.Resource{Id: "1rc1"},
+		State:                "updating-active",
+		Transitioning:        transitioningError,
+		TransitioningMessage: "bad credential",
+	}, nil).Once()
+
+	rep := r.updateEcr(context.Background(), mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	assert.Equal(t, statusFailed, rep.Results[0].Status)
+	assert.Contains(t, rep.Results[0].Error, "bad credential")
+	assert.Equal(t, "updating-active", rep.Results[0].State)
+}
+
+func TestTransition_activatesInactive(t *testing.T) {
+	r := &Rancher{TransitionTimeout: time.Second, TransitionPollInterval: time.Millisecond}
+	inactive := &client.RegistryCredential{Resource: client.Resource{Id: "1rc1"}, State: stateInactive, Transitioning: "no"}
+	mockRegistry, mockRegistryCredential := mockUpdatedCredential(inactive)
+	mockRegistryCredential.On("ActionActivate", inactive).Return(&client.Credential{}, nil)
+	mockRegistryCredential.On("ById", "1rc1").Return(
+		&client.RegistryCredential{Resource: client.Resource{Id: "1rc1"}, State: "activating", Transitioning: "yes"}, nil).Once()
+	mockRegistryCredential.On("ById", "1rc1").Return(
+		&client.RegistryCredential{Resource: client.Resource{Id: "1rc1"}, State: stateActive, Transitioning: "no"}, nil).Once()
+
+	rep := r.updateEcr(context.Background(), mockAuthorizationToken(), mockRegistry, mockRegistryCredential)
+
+	mockRegistryCredential.AssertExpectations(t)
+	assert.Equal(t, statusUpdated, rep.Results[0].Status)
+	assert.Equal(t, stateActive, rep.Results[0].State)
+}
+
+func TestTransition_timeout(t *testing.T) {
+	r := &Rancher{TransitionTimeout: 20 * time.Millisecond, TransitionPollInterval: time.Millisecond}
+	stuck := resourceState{State: "updating-active", Transitioning: "yes"}
+
+	state, err := r.waitForState(context.Background(), stateActive, stuck, func() (resourceState, error) {
+		return stuck, nil
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, stuck, state)
+}